@@ -14,6 +14,8 @@ import (
 
 	"github.com/onionpiece/alcorset/pkg/apis"
 	"github.com/onionpiece/alcorset/pkg/controller"
+	"github.com/onionpiece/alcorset/pkg/controller/alcorset"
+	"github.com/onionpiece/alcorset/pkg/webhook"
 	"github.com/onionpiece/alcorset/version"
 	ipclaim "github.com/onionpiece/ipclaim/pkg/apis"
 	vpcipclaim "github.com/onionpiece/vpcipclaim/pkg/apis"
@@ -39,6 +41,12 @@ var (
 	metricsPort         int32 = 8383
 	operatorMetricsPort int32 = 8686
 )
+
+// Change below variables to serve admission webhooks on different port or with different certs.
+var (
+	webhookPort    = 9443
+	webhookCertDir = "/tmp/k8s-webhook-server/serving-certs"
+)
 var log = logf.Log.WithName("cmd")
 
 func printVersion() {
@@ -57,6 +65,15 @@ func main() {
 	// controller-runtime)
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 
+	// Admission webhooks need serving certs under webhookCertDir, so they are disabled by default
+	enableWebhook := pflag.Bool("enable-webhook", false, "Serve validating and mutating admission webhooks for AlcorSet")
+
+	// Leaked IPClaims and VPCIPClaims are only reported by default
+	pflag.DurationVar(&alcorset.LeakedClaimSweepInterval, "leaked-claim-sweep-interval", alcorset.LeakedClaimSweepInterval,
+		"Interval to look for IPClaims and VPCIPClaims leaked by AlcorSet")
+	pflag.DurationVar(&alcorset.LeakedClaimGracePeriod, "leaked-claim-grace-period", alcorset.LeakedClaimGracePeriod,
+		"Release leaked IPClaims and VPCIPClaims of AlcorSets which are gone after they keep leaked for this period, 0 means never release")
+
 	pflag.Parse()
 
 	// Use a zap logr.Logger implementation. If none of the zap
@@ -96,6 +113,8 @@ func main() {
 	mgr, err := manager.New(cfg, manager.Options{
 		Namespace:          namespace,
 		MetricsBindAddress: fmt.Sprintf("%s:%d", metricsHost, metricsPort),
+		Port:               webhookPort,
+		CertDir:            webhookCertDir,
 	})
 	if err != nil {
 		log.Error(err, "")
@@ -128,6 +147,14 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Setup all Webhooks
+	if *enableWebhook {
+		if err := webhook.AddToManager(mgr); err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+	}
+
 	// Add the Metrics Service
 	addMetrics(ctx, cfg, namespace)
 
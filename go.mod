@@ -3,11 +3,13 @@ module github.com/onionpiece/alcorset
 go 1.13
 
 require (
+	github.com/go-logr/logr v0.1.0
 	github.com/onionpiece/ipclaim v0.0.4
 	github.com/onionpiece/saishang v0.0.0-20200622015946-208d3ffa771b
 	github.com/onionpiece/vpcapi v0.0.0-20200623034015-e1d214ce77a6
 	github.com/onionpiece/vpcipclaim v0.0.0-20200418094200-019f4ed6f392
 	github.com/operator-framework/operator-sdk v0.15.1
+	github.com/prometheus/client_golang v1.2.1
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/sys v0.0.0-20200622214017-ed371f2e16b4 // indirect
 	google.golang.org/genproto v0.0.0-20200623002339-fbb79eadd5eb // indirect
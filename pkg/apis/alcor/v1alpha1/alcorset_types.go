@@ -3,6 +3,7 @@ package v1alpha1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // AlcorSetSpec defines the desired state of AlcorSet
@@ -24,35 +25,234 @@ import (
 // Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
 type AlcorSetSpec struct {
 	Replicas int `json:"replicas"`
-	// IPs used for Pods if not empty, replicas should be smaller or equal to number of IPs
+	// IPs used for Pods if not empty, replicas should be smaller or equal to number of IPs.
+	// Pod with index N will use IPs[N], no IPClaim will be created. IPs of pods with index less than
+	// replicas cannot be changed, scale down before changing them. On VPC, VPCIPClaim named after pod with
+	// IPs[N] should be created before pod, AlcorSet only uses it and never releases it.
 	IPs []string `json:"ips,omitempty"`
-	// if IPs is empty, AlcorSet will try to claim IPs from given IPPool, only valid when OnVPC is false
+	// if IPs is empty, AlcorSet will try to claim IPs from given IPPool, only valid when OnVPC is false.
+	// SR-IOV vlan, gateway and mask of pods come from IPClaim status, or spec of the IPPool, so IPPool is
+	// required for IPs on SR-IOV
 	IPPool string `json:"ippool,omitempty"`
+	// what to do with IPClaim or VPCIPClaim of pod deleted for scaling down, default is Retain.
+	// Retain keeps the claim so pod with the same index gets the same IP after scaling up,
+	// Release deletes the claim after pod is gone
+	// +kubebuilder:validation:Enum=Retain;Release
+	IPRetentionPolicy IPRetentionPolicyType `json:"ipRetentionPolicy,omitempty"`
+	// seconds to wait after pod is gone before its IPClaim or VPCIPClaim is deleted, for network of pod
+	// to be torn down on node, e.g. pod force deleted. Works for scaling down with ipRetentionPolicy
+	// Release and AlcorSet deleted
+	IPQuarantineSeconds int64 `json:"ipQuarantineSeconds,omitempty"`
+	// if true, IPClaims or VPCIPClaims are left behind as orphans when AlcorSet is deleted, and orphaned
+	// claims left by AlcorSet with the same name are adopted, so pods keep their IPs across AlcorSet
+	// deleted and recreated. Claims are also left behind when AlcorSet is deleted with propagationPolicy Orphan
+	IPAdoption bool `json:"ipAdoption,omitempty"`
 	// whether AlcorSet is deployed on VPC
 	OnVPC bool `json:"onVpc,omitempty"`
-	// currently, only SR-IOV scenario supports Mbps
+	// network backend to setup claimed or fixed IPs for pods, default is vpc if onVpc is true, otherwise sriov
+	// +kubebuilder:validation:Enum=vpc;sriov;calico
+	NetworkBackend string `json:"networkBackend,omitempty"`
+	// currently, only SR-IOV scenario supports Mbps, 0 means no rate limit
 	Mbps           int    `json:"mbps,omitempty"`
 	HostnamePrefix string `json:"hostnamePrefix"`
-	// whether raise Pod one by one in order
-	Sequence        bool                   `json:"sequence,omitempty"`
+	// if set, a headless Service with this name will be created and used as subdomain of pods,
+	// so pod with index N can be resolved as hostnamePrefix-N.serviceName.namespace.svc
+	ServiceName string `json:"serviceName,omitempty"`
+	// whether raise Pod one by one in order, deprecated by podManagementPolicy OrderedReady
+	Sequence bool `json:"sequence,omitempty"`
+	// how pods are created and deleted, default is OrderedReady if sequence is true, otherwise Parallel
+	// +kubebuilder:validation:Enum=OrderedReady;Parallel;Burst
+	PodManagementPolicy PodManagementPolicyType `json:"podManagementPolicy,omitempty"`
+	// max number of pods created but not ready at the same time, only for podManagementPolicy Burst
+	BurstSize       int                    `json:"burstSize,omitempty"`
 	PodTemplateSpec corev1.PodTemplateSpec `json:"template"`
+	// number of pods, from index 0, created by StagePodTemplateSpec, pods keep their hostnames and IPs
+	StageReplicas int `json:"stageReplicas,omitempty"`
+	// pod template for pods in stage partition, it will replace template once stageReplicas >= replicas
+	StagePodTemplateSpec *corev1.PodTemplateSpec `json:"stagePodSpec,omitempty"`
+	// max number of pods unavailable during rolling update, can be number or percentage of replicas,
+	// default is 1, and it's always 1 when sequence is true
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// if set, a PodDisruptionBudget selecting pods of AlcorSet will be created and owned by AlcorSet
+	DisruptionBudget *AlcorSetDisruptionBudget `json:"disruptionBudget,omitempty"`
+	// PVCs created for each pod, named <claim name>-<pod name>, and mounted as volume named <claim name>
+	VolumeClaimTemplates []corev1.PersistentVolumeClaim `json:"volumeClaimTemplates,omitempty"`
+	// whether PVCs created from volumeClaimTemplates are deleted, default is retaining them
+	PersistentVolumeClaimRetentionPolicy *AlcorSetPersistentVolumeClaimRetentionPolicy `json:"persistentVolumeClaimRetentionPolicy,omitempty"`
+	// if set, pods stuck in terminating on nodes which are deleted or tainted out-of-service will be
+	// force deleted after timeout, and recreated on other nodes
+	UnreachableNodePolicy *AlcorSetUnreachableNodePolicy `json:"unreachableNodePolicy,omitempty"`
+}
+
+// AlcorSetUnreachableNodePolicy describes when pods on unreachable nodes are force deleted
+type AlcorSetUnreachableNodePolicy struct {
+	// seconds to wait after pod termination grace period ends, 0 means force delete pod once
+	// its node is confirmed deleted or tainted out-of-service
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// PodManagementPolicyType is policy for creating and deleting pods of AlcorSet
+type PodManagementPolicyType string
+
+const (
+	// OrderedReadyPodManagement creates pods one by one by index, and the next one will be created
+	// after all pods are running and ready, pods are deleted one by one from the biggest index
+	OrderedReadyPodManagement PodManagementPolicyType = "OrderedReady"
+	// ParallelPodManagement creates and deletes all pods at once
+	ParallelPodManagement PodManagementPolicyType = "Parallel"
+	// BurstPodManagement creates pods in parallel, but no more than burstSize pods are not ready at the same time
+	BurstPodManagement PodManagementPolicyType = "Burst"
+)
+
+// IPRetentionPolicyType is policy for IPs claimed for pods with index no less than replicas
+type IPRetentionPolicyType string
+
+const (
+	// RetainIPRetentionPolicy keeps claims until AlcorSet is deleted
+	RetainIPRetentionPolicy IPRetentionPolicyType = "Retain"
+	// ReleaseIPRetentionPolicy deletes claims once pods are gone
+	ReleaseIPRetentionPolicy IPRetentionPolicyType = "Release"
+)
+
+// PersistentVolumeClaimRetentionPolicyType is policy for PVCs created from volumeClaimTemplates
+type PersistentVolumeClaimRetentionPolicyType string
+
+const (
+	// RetainPersistentVolumeClaimRetentionPolicyType keeps PVCs
+	RetainPersistentVolumeClaimRetentionPolicyType PersistentVolumeClaimRetentionPolicyType = "Retain"
+	// DeletePersistentVolumeClaimRetentionPolicyType deletes PVCs after their pods are deleted
+	DeletePersistentVolumeClaimRetentionPolicyType PersistentVolumeClaimRetentionPolicyType = "Delete"
+)
+
+// AlcorSetPersistentVolumeClaimRetentionPolicy describes when PVCs created from volumeClaimTemplates are deleted
+type AlcorSetPersistentVolumeClaimRetentionPolicy struct {
+	// for AlcorSet deleted, default is Retain
+	// +kubebuilder:validation:Enum=Retain;Delete
+	WhenDeleted PersistentVolumeClaimRetentionPolicyType `json:"whenDeleted,omitempty"`
+	// for pods with index no less than replicas, default is Retain
+	// +kubebuilder:validation:Enum=Retain;Delete
+	WhenScaled PersistentVolumeClaimRetentionPolicyType `json:"whenScaled,omitempty"`
+}
+
+// AlcorSetDisruptionBudget defines PodDisruptionBudget for pods of AlcorSet,
+// only one of minAvailable and maxUnavailable should be set
+type AlcorSetDisruptionBudget struct {
+	// number or percentage of pods should be available after eviction, number larger than replicas
+	// will be treated as replicas
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+	// number or percentage of pods can be unavailable after eviction
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// AlcorSetConditionType is type of condition of AlcorSet
+type AlcorSetConditionType string
+
+const (
+	// AlcorSetIPsClaimed means IPs for all replicas are claimed
+	AlcorSetIPsClaimed AlcorSetConditionType = "IPsClaimed"
+	// AlcorSetPodsReady means all replicas are ready
+	AlcorSetPodsReady AlcorSetConditionType = "PodsReady"
+	// AlcorSetProgressing means pods are scaling or rolling update
+	AlcorSetProgressing AlcorSetConditionType = "Progressing"
+	// AlcorSetDegraded means AlcorSet fails to reconcile or pods keep failing
+	AlcorSetDegraded AlcorSetConditionType = "Degraded"
+)
+
+// AlcorSetCondition describes the state of AlcorSet at a certain point
+type AlcorSetCondition struct {
+	Type AlcorSetConditionType `json:"type"`
+	// one of True, False, Unknown
+	Status             corev1.ConditionStatus `json:"status"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
+	// one word CamelCase reason for the condition's last transition
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// TeardownPhase is phase of tearing down pod and releasing its IP
+type TeardownPhase string
+
+const (
+	// TeardownPodTerminating means pod is deleted but not gone yet
+	TeardownPodTerminating TeardownPhase = "PodTerminating"
+	// TeardownPodGone means pod is gone, and IP is in quarantine
+	TeardownPodGone TeardownPhase = "PodGone"
+	// TeardownNetworkTornDown means quarantine has passed, network of pod is considered torn down
+	TeardownNetworkTornDown TeardownPhase = "NetworkTornDown"
+	// TeardownClaimDeleted means IPClaim or VPCIPClaim is deleted, and IP is handed back
+	TeardownClaimDeleted TeardownPhase = "ClaimDeleted"
+)
+
+// AlcorSetTeardown describes teardown of pod with a certain index whose IP is to be released,
+// phases go in order PodTerminating, PodGone, NetworkTornDown and ClaimDeleted
+type AlcorSetTeardown struct {
+	Ordinal            int           `json:"ordinal"`
+	IP                 string        `json:"ip,omitempty"`
+	Phase              TeardownPhase `json:"phase"`
+	LastTransitionTime metav1.Time   `json:"lastTransitionTime,omitempty"`
+}
+
+// AlcorSetMember describes pod with a certain index of AlcorSet
+type AlcorSetMember struct {
+	Ordinal  int    `json:"ordinal"`
+	PodName  string `json:"podName"`
+	Hostname string `json:"hostname"`
+	// IP pod is setup with, or IP from spec.ips, or claimed by IPClaim or VPCIPClaim before pod is created
+	IP string `json:"ip,omitempty"`
+	// name of IPClaim or VPCIPClaim, empty for fixed IP unless it's pinned by VPCIPClaim
+	ClaimName string `json:"claimName,omitempty"`
+	// VPC NIC ID and MAC from VPCIPClaim status
+	NICID    string `json:"nicID,omitempty"`
+	NICMAC   string `json:"nicMAC,omitempty"`
+	NodeName string `json:"nodeName,omitempty"`
+	Ready    bool   `json:"ready"`
+	// times pod is recreated since it was failed or succeeded
+	Restarts int32 `json:"restarts,omitempty"`
+	// why pod was failed or succeeded last time
+	LastTerminationReason string       `json:"lastTerminationReason,omitempty"`
+	LastRestartTime       *metav1.Time `json:"lastRestartTime,omitempty"`
 }
 
 // AlcorSetStatus defines the observed state of AlcorSet
 // Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
 type AlcorSetStatus struct {
-	// Number of pods which are ready
-	Count      int      `json:"count"`
+	// Number of pods created for indexes less than replicas, terminating ones excluded
+	Count int `json:"count"`
+	// IPs of IPClaims or VPCIPClaims of AlcorSet ordered by index, including retained ones
 	ClaimedIPs []string `json:"claimedIPs"`
-	Status     string   `json:"status"`
+	// summary of conditions
+	Status string `json:"status"`
+	// IPs bound to pods observed in last sync, keyed by pod name. Pods out of date, failed or succeeded are not
+	// bound, since they are deleted by AlcorSet, bound pods gone are reported missing when recreated
+	PodIPs map[string]string `json:"podIPs,omitempty"`
+	// number of pods which are not terminating, used by scale subresource
+	Replicas int `json:"replicas"`
+	// label selector for pods, used by scale subresource and HorizontalPodAutoscaler
+	Selector string `json:"selector,omitempty"`
+	// number of pods which are running and ready
+	ReadyReplicas int `json:"readyReplicas"`
+	// number of pods created by the pod template expected for their index
+	UpdatedReplicas int `json:"updatedReplicas"`
+	// generation of AlcorSet observed by controller
+	ObservedGeneration int64               `json:"observedGeneration,omitempty"`
+	Conditions         []AlcorSetCondition `json:"conditions,omitempty"`
+	// pods of AlcorSet ordered by index, from 0 to replicas-1
+	Members []AlcorSetMember `json:"members,omitempty"`
+	// IPs kept for indexes no less than replicas by ipRetentionPolicy Retain, not used by any pod
+	RetainedIPs []string `json:"retainedIPs,omitempty"`
+	// pods being torn down whose IPs are to be released, ordered by index
+	Teardowns []AlcorSetTeardown `json:"teardowns,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // AlcorSet is the Schema for the alcorsets API
 // +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
 // +kubebuilder:resource:path=alcorsets,scope=Namespaced,shortName=als
 // +kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.status`,priority=0
+// +kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyReplicas`,priority=0
+// +kubebuilder:printcolumn:name="Updated",type=integer,JSONPath=`.status.updatedReplicas`,priority=1
 type AlcorSet struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -5,7 +5,9 @@
 package v1alpha1
 
 import (
+	v1 "k8s.io/api/core/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	intstr "k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
@@ -36,6 +38,49 @@ func (in *AlcorSet) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AlcorSetCondition) DeepCopyInto(out *AlcorSetCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AlcorSetCondition.
+func (in *AlcorSetCondition) DeepCopy() *AlcorSetCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(AlcorSetCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AlcorSetDisruptionBudget) DeepCopyInto(out *AlcorSetDisruptionBudget) {
+	*out = *in
+	if in.MinAvailable != nil {
+		in, out := &in.MinAvailable, &out.MinAvailable
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+	if in.MaxUnavailable != nil {
+		in, out := &in.MaxUnavailable, &out.MaxUnavailable
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AlcorSetDisruptionBudget.
+func (in *AlcorSetDisruptionBudget) DeepCopy() *AlcorSetDisruptionBudget {
+	if in == nil {
+		return nil
+	}
+	out := new(AlcorSetDisruptionBudget)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AlcorSetList) DeepCopyInto(out *AlcorSetList) {
 	*out = *in
@@ -69,6 +114,42 @@ func (in *AlcorSetList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AlcorSetMember) DeepCopyInto(out *AlcorSetMember) {
+	*out = *in
+	if in.LastRestartTime != nil {
+		in, out := &in.LastRestartTime, &out.LastRestartTime
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AlcorSetMember.
+func (in *AlcorSetMember) DeepCopy() *AlcorSetMember {
+	if in == nil {
+		return nil
+	}
+	out := new(AlcorSetMember)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AlcorSetPersistentVolumeClaimRetentionPolicy) DeepCopyInto(out *AlcorSetPersistentVolumeClaimRetentionPolicy) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AlcorSetPersistentVolumeClaimRetentionPolicy.
+func (in *AlcorSetPersistentVolumeClaimRetentionPolicy) DeepCopy() *AlcorSetPersistentVolumeClaimRetentionPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(AlcorSetPersistentVolumeClaimRetentionPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AlcorSetSpec) DeepCopyInto(out *AlcorSetSpec) {
 	*out = *in
@@ -78,6 +159,38 @@ func (in *AlcorSetSpec) DeepCopyInto(out *AlcorSetSpec) {
 		copy(*out, *in)
 	}
 	in.PodTemplateSpec.DeepCopyInto(&out.PodTemplateSpec)
+	if in.StagePodTemplateSpec != nil {
+		in, out := &in.StagePodTemplateSpec, &out.StagePodTemplateSpec
+		*out = new(v1.PodTemplateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MaxUnavailable != nil {
+		in, out := &in.MaxUnavailable, &out.MaxUnavailable
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+	if in.DisruptionBudget != nil {
+		in, out := &in.DisruptionBudget, &out.DisruptionBudget
+		*out = new(AlcorSetDisruptionBudget)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.VolumeClaimTemplates != nil {
+		in, out := &in.VolumeClaimTemplates, &out.VolumeClaimTemplates
+		*out = make([]v1.PersistentVolumeClaim, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PersistentVolumeClaimRetentionPolicy != nil {
+		in, out := &in.PersistentVolumeClaimRetentionPolicy, &out.PersistentVolumeClaimRetentionPolicy
+		*out = new(AlcorSetPersistentVolumeClaimRetentionPolicy)
+		**out = **in
+	}
+	if in.UnreachableNodePolicy != nil {
+		in, out := &in.UnreachableNodePolicy, &out.UnreachableNodePolicy
+		*out = new(AlcorSetUnreachableNodePolicy)
+		**out = **in
+	}
 	return
 }
 
@@ -99,6 +212,39 @@ func (in *AlcorSetStatus) DeepCopyInto(out *AlcorSetStatus) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.PodIPs != nil {
+		in, out := &in.PodIPs, &out.PodIPs
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]AlcorSetCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]AlcorSetMember, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RetainedIPs != nil {
+		in, out := &in.RetainedIPs, &out.RetainedIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Teardowns != nil {
+		in, out := &in.Teardowns, &out.Teardowns
+		*out = make([]AlcorSetTeardown, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -111,3 +257,36 @@ func (in *AlcorSetStatus) DeepCopy() *AlcorSetStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AlcorSetTeardown) DeepCopyInto(out *AlcorSetTeardown) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AlcorSetTeardown.
+func (in *AlcorSetTeardown) DeepCopy() *AlcorSetTeardown {
+	if in == nil {
+		return nil
+	}
+	out := new(AlcorSetTeardown)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AlcorSetUnreachableNodePolicy) DeepCopyInto(out *AlcorSetUnreachableNodePolicy) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AlcorSetUnreachableNodePolicy.
+func (in *AlcorSetUnreachableNodePolicy) DeepCopy() *AlcorSetUnreachableNodePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(AlcorSetUnreachableNodePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -3,17 +3,21 @@ package alcorset
 import (
 	"context"
 	"fmt"
-	"log"
+	"time"
 
+	"github.com/go-logr/logr"
 	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
 	ipclaim "github.com/onionpiece/ipclaim/pkg/apis/alcor/v1alpha1"
 	vpcipclaim "github.com/onionpiece/vpcipclaim/pkg/apis/alcor/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
@@ -27,7 +31,11 @@ func Add(mgr manager.Manager) error {
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileAlcorSet{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+	return &ReconcileAlcorSet{
+		client:   mgr.GetClient(),
+		scheme:   mgr.GetScheme(),
+		recorder: mgr.GetEventRecorderFor("alcorset-controller"),
+	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -52,6 +60,22 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for services, since they are subresources
+	if err = c.Watch(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &alcorv1alpha1.AlcorSet{},
+	}); err != nil {
+		return err
+	}
+
+	// Watch for poddisruptionbudgets, since they are subresources
+	if err = c.Watch(&source.Kind{Type: &policyv1beta1.PodDisruptionBudget{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &alcorv1alpha1.AlcorSet{},
+	}); err != nil {
+		return err
+	}
+
 	// Watch for vpcipclaims, since they are subresources
 	if err = c.Watch(&source.Kind{Type: &vpcipclaim.VPCIPClaim{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
@@ -68,9 +92,26 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Sweep claims leaked by AlcorSet periodically, claims out of range are torn down by reconcile
+	sweeper := newClaimSweeper(mgr)
+	if err = c.Watch(&source.Channel{Source: sweeper.events}, &handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+	if err = mgr.Add(sweeper); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+var log = logf.Log.WithName("controller_alcorset")
+
+// alcorSetLogger returns logger with fields of AlcorSet, so history of one AlcorSet can be filtered
+func alcorSetLogger(als *alcorv1alpha1.AlcorSet) logr.Logger {
+	return log.WithValues("namespace", als.Namespace, "name", als.Name, "generation", als.Generation,
+		"backend", getNetworkBackend(als))
+}
+
 // blank assignment to verify that ReconcileAlcorSet implements reconcile.Reconciler
 var _ reconcile.Reconciler = &ReconcileAlcorSet{}
 
@@ -78,22 +119,35 @@ var _ reconcile.Reconciler = &ReconcileAlcorSet{}
 type ReconcileAlcorSet struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
-	client client.Client
-	scheme *runtime.Scheme
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
 }
 
 // Reconcile reads that state of the cluster for a AlcorSet object and makes changes based on the state read
 // and what is in the AlcorSet.Spec
 // The Controller will requeue the Request to be processed again if the returned error is non-nil or
 // Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
-func (r *ReconcileAlcorSet) Reconcile(request reconcile.Request) (reconcile.Result, error) {
-	log.Printf("Reconciling AlcorSet for %v", request.NamespacedName)
+func (r *ReconcileAlcorSet) Reconcile(request reconcile.Request) (result reconcile.Result, err error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.V(1).Info("Reconciling AlcorSet")
+	phase := phaseSteady
+	defer func() {
+		outcome := resultSuccess
+		if err != nil {
+			outcome = resultError
+		} else if result.Requeue || result.RequeueAfter > 0 {
+			outcome = resultRequeue
+		}
+		reconcileTotal.WithLabelValues(phase, outcome).Inc()
+	}()
 
 	als := &alcorv1alpha1.AlcorSet{}
-	err := r.client.Get(context.TODO(), request.NamespacedName, als)
+	err = r.client.Get(context.TODO(), request.NamespacedName, als)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			log.Print("Request object not found, could have been deleted after reconcile request.")
+			reqLogger.V(1).Info("Request object not found, could have been deleted after reconcile request.")
+			deleteAlcorSetMetrics(request.Namespace, request.Name)
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
 			return reconcile.Result{}, nil
@@ -102,8 +156,10 @@ func (r *ReconcileAlcorSet) Reconcile(request reconcile.Request) (reconcile.Resu
 		return reconcile.Result{}, err
 	}
 
+	reqLogger = alcorSetLogger(als)
 	if als.GetDeletionTimestamp() != nil {
-		log.Print("AlcorSet has been marked as deleted, do cleanup")
+		reqLogger.Info("AlcorSet has been marked as deleted, do cleanup")
+		phase = phaseFalling
 		/*
 		 *	NOTE: consider delete pod before deleting IPClaim or VPCIPClaim created by AlcorSet
 		 *	For example, if VPCIPClaim get deleted before pod with big terminationGracePeriodSeconds,
@@ -113,56 +169,82 @@ func (r *ReconcileAlcorSet) Reconcile(request reconcile.Request) (reconcile.Resu
 		 *	It will be harmful to IPClaim scenario, and VPCIPClaim(specially for case pods
 		 *	communicating on the same node)
 		 */
-		pods, err := r.getPods(als)
+		// with propagationPolicy Orphan, pods are orphaned by garbage collector, and claims are kept for them
+		orphan, err := r.podsOrphaned(als)
 		if err != nil {
-			// Sequence case ...
-			if err.Error() == PodsRaisingPhase {
-				log.Print("Waiting pod raise up")
-				return reconcile.Result{}, nil
-			} else if err.Error() == PodsFallingPhase {
-				log.Print("Waiting pod tear down")
-				return reconcile.Result{Requeue: true}, nil
-			}
-			log.Print(err, "Failed to get pods")
-			return reconcile.Result{}, err
-		}
-		if len(pods.Items) > 0 {
-			err := r.tearDownPods(als, pods, true)
 			return reconcile.Result{}, err
 		}
-
-		if contains(als.GetFinalizers(), FinalizerIPClaim) {
-			if len(als.Status.ClaimedIPs) > 0 {
-				if err := r.deleteIPClaims(als); err != nil {
-					return reconcile.Result{}, err
+		pods := &corev1.PodList{}
+		if !orphan {
+			requeueAfter, err := r.forceDeleteUnreachablePods(als)
+			if err != nil {
+				return reconcile.Result{}, err
+			}
+			pods, err = r.getPods(als)
+			if err != nil {
+				// Sequence case ...
+				if err.Error() == PodsRaisingPhase {
+					reqLogger.V(1).Info("Waiting pod raise up")
+					return reconcile.Result{}, nil
+				} else if err.Error() == PodsFallingPhase {
+					reqLogger.V(1).Info("Waiting pod tear down")
+					return reconcile.Result{Requeue: true}, nil
 				}
+				reqLogger.Error(err, "Failed to get pods")
+				return reconcile.Result{}, err
 			}
-			if err := r.removeFinalizer(als, FinalizerIPClaim); err != nil {
-				return reconcile.Result{}, fmt.Errorf("Failed to remove finalizer %s, found error: %v", FinalizerIPClaim, err)
+			if len(pods.Items) > 0 {
+				err := r.tearDownPods(als, pods, true)
+				return reconcile.Result{RequeueAfter: requeueAfter}, err
 			}
-		} else if contains(als.GetFinalizers(), FinalizerVPCIPClaim) {
-			if len(als.Status.ClaimedIPs) > 0 {
-				if err := r.deleteVPCIPClaims(als); err != nil {
+			if whenDeleted, _ := getPVCRetentionPolicy(als); whenDeleted == alcorv1alpha1.DeletePersistentVolumeClaimRetentionPolicyType {
+				if err := r.deletePVCs(als, pods, 0); err != nil {
 					return reconcile.Result{}, err
 				}
 			}
-			if err := r.removeFinalizer(als, FinalizerVPCIPClaim); err != nil {
-				return reconcile.Result{}, fmt.Errorf("Failed to remove finalizer %s, found error: %v", FinalizerVPCIPClaim, err)
+		}
+
+		backend, err := r.getBackend(als)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		stored := als.Status.DeepCopy()
+		if finalizer := backend.Finalizer(); contains(als.GetFinalizers(), finalizer) {
+			if orphan || als.Spec.IPAdoption {
+				// leave claims behind to be adopted
+				if err := r.orphanIPs(als, backend); err != nil {
+					return reconcile.Result{}, err
+				}
+			} else if done, requeueAfter, err := r.tearDownIPs(als, pods, backend, 0); err != nil {
+				// all pods are gone, release IPs index by index after quarantine
+				return reconcile.Result{}, err
+			} else if !done {
+				// teardowns are checked again after requeue
+				if err := r.updateStatus(als, stored); err != nil {
+					return reconcile.Result{}, fmt.Errorf("Failed to update teardowns in status, since: %v", err)
+				}
+				return reconcile.Result{Requeue: requeueAfter == 0, RequeueAfter: requeueAfter}, nil
+			} else if err := r.releaseIPs(als, backend); err != nil {
+				// claims not named after pods, if any
+				return reconcile.Result{}, err
 			}
+			if err := r.removeFinalizer(als, finalizer); err != nil {
+				return reconcile.Result{}, fmt.Errorf("Failed to remove finalizer %s, found error: %v", finalizer, err)
+			}
+			finalizerRemovalDuration.WithLabelValues(getNetworkBackend(als)).Observe(time.Since(als.DeletionTimestamp.Time).Seconds())
+			deleteAlcorSetMetrics(als.Namespace, als.Name)
 		}
 		// it's safe to exit, either no finalizers, or all subresources are deleted sucessfully on api
 		return reconcile.Result{}, nil
 	}
 
+	backend, err := r.getBackend(als)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
 	finsToAdd := []string{}
-	if als.Spec.OnVPC {
-		if !contains(als.GetFinalizers(), FinalizerVPCIPClaim) {
-			finsToAdd = append(finsToAdd, FinalizerVPCIPClaim)
-		}
-	} else {
-		if !contains(als.GetFinalizers(), FinalizerIPClaim) {
-			finsToAdd = append(finsToAdd, FinalizerIPClaim)
-		}
+	if !contains(als.GetFinalizers(), backend.Finalizer()) {
+		finsToAdd = append(finsToAdd, backend.Finalizer())
 	}
 	if len(finsToAdd) != 0 {
 		if err := r.addFinalizers(als, finsToAdd); err != nil {
@@ -170,38 +252,125 @@ func (r *ReconcileAlcorSet) Reconcile(request reconcile.Request) (reconcile.Resu
 		}
 	}
 
-	// init status
-	if err := r.initStatus(als); err != nil {
+	// status changed in memory by reconcilePods is compared with stored one before written
+	stored := als.Status.DeepCopy()
+	result, err = r.reconcilePods(als)
+	if statusErr := r.syncStatus(als, stored, err); statusErr != nil {
+		reqLogger.Error(statusErr, "Failed to sync status")
+		if err == nil {
+			return reconcile.Result{}, statusErr
+		}
+	}
+	phase = getPhase(&als.Status)
+	if _, ok := err.(*ipClaimError); ok {
+		// IP claim failure has been recorded in status, just retry
+		reqLogger.Info("Failed to claim IP, will requeue", "error", err.Error())
+		return reconcile.Result{Requeue: true}, nil
+	}
+	return result, err
+}
+
+// reconcilePods creates, deletes and updates pods according to AlcorSet.Spec
+func (r *ReconcileAlcorSet) reconcilePods(als *alcorv1alpha1.AlcorSet) (reconcile.Result, error) {
+	// pods stuck in terminating on unreachable nodes, otherwise sequence case will be stalled
+	requeueAfter, err := r.forceDeleteUnreachablePods(als)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	// failed or succeeded pods, with backoff
+	restartAfter, err := r.restartTerminatedPods(als)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if restartAfter > 0 && (requeueAfter == 0 || restartAfter < requeueAfter) {
+		requeueAfter = restartAfter
+	}
+	// IPs of pods scaled down, with quarantine
+	releaseAfter, err := r.releaseIdleIPs(als)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if releaseAfter > 0 && (requeueAfter == 0 || releaseAfter < requeueAfter) {
+		requeueAfter = releaseAfter
+	}
+	result, err := r.syncPods(als)
+	if !result.Requeue && requeueAfter > 0 && (result.RequeueAfter == 0 || requeueAfter < result.RequeueAfter) {
+		result.RequeueAfter = requeueAfter
+	}
+	return result, err
+}
+
+// syncPods creates, deletes and updates pods by index
+func (r *ReconcileAlcorSet) syncPods(als *alcorv1alpha1.AlcorSet) (reconcile.Result, error) {
+	reqLogger := alcorSetLogger(als)
+	// PodDisruptionBudget follows replicas
+	if err := r.syncDisruptionBudget(als); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// claims left by deleted AlcorSet with the same name
+	if err := r.adoptIPs(als); err != nil {
 		return reconcile.Result{}, err
 	}
 
+	// headless Service for DNS records of pods
+	if err := r.syncHeadlessService(als); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// fixed IPs should be enough for replicas
+	if status := checkFixedIPs(als); status != "" {
+		reqLogger.Info("Cannot use fixed IPs", "reason", status)
+		return reconcile.Result{}, nil
+	}
+
+	// stagePodSpec covers all replicas, promote it as template
+	if promoted, err := r.promoteStage(als); err != nil {
+		return reconcile.Result{}, err
+	} else if promoted {
+		return reconcile.Result{Requeue: true}, nil
+	}
+
 	pods, err := r.getPods(als)
 	if err != nil {
 		// Sequence case ...
 		if err.Error() == PodsRaisingPhase {
-			log.Print("Waiting pod raise up")
+			reqLogger.V(1).Info("Waiting pod raise up")
+			// only recorded when scaling down gets blocked, status keeps it blocked until it's not
+			if cond := getCondition(&als.Status, alcorv1alpha1.AlcorSetProgressing); hasPodsBeyondReplicas(als, pods) &&
+				(cond == nil || cond.Reason != ReasonScaleDownBlocked) {
+				r.recorder.Event(als, corev1.EventTypeNormal, ReasonScaleDownBlocked, "Waiting all pods running and ready before deleting pod")
+			}
 			return reconcile.Result{}, nil
 		} else if err.Error() == PodsFallingPhase {
-			log.Print("Waiting pod tear down")
+			reqLogger.V(1).Info("Waiting pod tear down")
 			return reconcile.Result{Requeue: true}, nil
 		}
-		log.Print(err, "Failed to get pods")
+		reqLogger.Error(err, "Failed to get pods")
 		return reconcile.Result{}, err
 	}
 
-	if len(pods.Items) > als.Spec.Replicas {
-		log.Print("Going to tear down pods...")
+	missing := getMissingIndexes(als, pods)
+	if hasPodsBeyondReplicas(als, pods) {
+		reqLogger.Info("Going to tear down pods")
 		err := r.tearDownPods(als, pods, false)
 		return reconcile.Result{}, err
-	} else if len(pods.Items) != als.Spec.Replicas {
-		log.Print(fmt.Sprintf("Pod missing, current pods count: %d", len(pods.Items)))
-		if requeue, err := r.createPod(als, pods); err != nil {
+	} else if len(missing) != 0 {
+		reqLogger.Info("Pod missing", "pods", len(pods.Items), "missingOrdinals", missing)
+		if requeue, err := r.createPod(als, pods, missing); err != nil {
 			return reconcile.Result{}, err
 		} else if requeue {
 			return reconcile.Result{Requeue: true}, nil
 		}
+	} else if updating, err := r.rollingUpdate(als, pods); err != nil {
+		return reconcile.Result{}, err
+	} else if updating {
+		reqLogger.Info("Rolling update pods")
+	} else if _, whenScaled := getPVCRetentionPolicy(als); whenScaled == alcorv1alpha1.DeletePersistentVolumeClaimRetentionPolicyType {
+		// pods beyond replicas are gone, their PVCs can be deleted
+		return reconcile.Result{}, r.deletePVCs(als, pods, als.Spec.Replicas)
 	} else {
-		log.Print("Nothing to do...")
+		reqLogger.V(1).Info("Nothing to do")
 	}
 	return reconcile.Result{}, nil
 }
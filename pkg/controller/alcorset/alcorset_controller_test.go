@@ -0,0 +1,183 @@
+package alcorset
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/onionpiece/alcorset/pkg/apis"
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	ipclaimapis "github.com/onionpiece/ipclaim/pkg/apis"
+	ipclaim "github.com/onionpiece/ipclaim/pkg/apis/alcor/v1alpha1"
+	vpcipclaimapis "github.com/onionpiece/vpcipclaim/pkg/apis"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testNamespace = "default"
+
+// newTestReconciler returns ReconcileAlcorSet with a fake client holding given objects
+func newTestReconciler(t *testing.T, objs ...runtime.Object) *ReconcileAlcorSet {
+	s := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme, apis.AddToScheme, ipclaimapis.AddToScheme, vpcipclaimapis.AddToScheme,
+	} {
+		if err := addToScheme(s); err != nil {
+			t.Fatalf("Failed to setup scheme, since: %v", err)
+		}
+	}
+	return &ReconcileAlcorSet{
+		client:   fake.NewFakeClientWithScheme(s, objs...),
+		scheme:   s,
+		recorder: record.NewFakeRecorder(1000),
+	}
+}
+
+// newTestAlcorSet returns AlcorSet on calico backend claiming IPs from an ippool
+func newTestAlcorSet(replicas int) *alcorv1alpha1.AlcorSet {
+	return &alcorv1alpha1.AlcorSet{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: alcorv1alpha1.SchemeGroupVersion.String(),
+			Kind:       "AlcorSet",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "als",
+			Namespace: testNamespace,
+			UID:       "als-uid",
+		},
+		Spec: alcorv1alpha1.AlcorSetSpec{
+			Replicas:       replicas,
+			IPPool:         "pool",
+			NetworkBackend: CalicoBackend,
+			HostnamePrefix: "als",
+			PodTemplateSpec: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app", Image: "app"}},
+				},
+			},
+		},
+	}
+}
+
+// newTestPod returns pod with given index using ip, running and ready if ready is true
+func newTestPod(als *alcorv1alpha1.AlcorSet, idx int, ip string, ready bool) *corev1.Pod {
+	annotations := map[string]string{CalicoAnnotationKey: `["` + ip + `"]`}
+	pod := newPodForCR(als, getPodName(als, idx), getPodHostname(als, idx), false, annotations)
+	pod.OwnerReferences = []metav1.OwnerReference{*newOwnerReference(als)}
+	pod.Status.Phase = corev1.PodPending
+	if ready {
+		pod.Status.Phase = corev1.PodRunning
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	}
+	return pod
+}
+
+// newTestIPClaim returns IPClaim for pod with given index, with ip allocated if not empty
+func newTestIPClaim(als *alcorv1alpha1.AlcorSet, idx int, ip string) *ipclaim.IPClaim {
+	claim := newIPClaimForCR(als, getPodName(als, idx))
+	claim.Status.IP = ip
+	return claim
+}
+
+func listTestPods(t *testing.T, r *ReconcileAlcorSet, als *alcorv1alpha1.AlcorSet) *corev1.PodList {
+	pods, err := r.listPods(als)
+	if err != nil {
+		t.Fatalf("Failed to list pods, since: %v", err)
+	}
+	return pods
+}
+
+func getTestIPClaim(t *testing.T, r *ReconcileAlcorSet, name string) *ipclaim.IPClaim {
+	claims := &ipclaim.IPClaimList{}
+	if err := r.client.List(context.TODO(), claims, client.InNamespace(testNamespace)); err != nil {
+		t.Fatalf("Failed to list ipclaims, since: %v", err)
+	}
+	for i := range claims.Items {
+		if claims.Items[i].Name == name {
+			return &claims.Items[i]
+		}
+	}
+	return nil
+}
+
+// assertNoSharedIPs fails if two existing pods, terminating ones included, use the same IP
+func assertNoSharedIPs(t *testing.T, r *ReconcileAlcorSet, als *alcorv1alpha1.AlcorSet) {
+	t.Helper()
+	used := map[string]string{}
+	for _, pod := range listTestPods(t, r, als).Items {
+		ips := []string{}
+		if err := json.Unmarshal([]byte(pod.Annotations[CalicoAnnotationKey]), &ips); err != nil {
+			t.Fatalf("Failed to parse IPs of pod %s, since: %v", pod.Name, err)
+		}
+		for _, ip := range ips {
+			if other, ok := used[ip]; ok {
+				t.Fatalf("IP %s is used by both pod %s and %s", ip, other, pod.Name)
+			}
+			used[ip] = pod.Name
+		}
+	}
+}
+
+// countEvents returns number of events with given reason recorded so far, events are drained
+func countEvents(r *ReconcileAlcorSet, reason string) int {
+	recorder := r.recorder.(*record.FakeRecorder)
+	count := 0
+	for {
+		select {
+		case event := <-recorder.Events:
+			if strings.Contains(event, " "+reason+" ") {
+				count++
+			}
+		default:
+			return count
+		}
+	}
+}
+
+func TestScaleDownBlockedEvent(t *testing.T) {
+	als := newTestAlcorSet(1)
+	als.Spec.PodManagementPolicy = alcorv1alpha1.OrderedReadyPodManagement
+	// pod 1 is to be deleted, but pod 0 is not ready
+	pod0 := newTestPod(als, 0, testIP(0), false)
+	pod1 := newTestPod(als, 1, testIP(1), true)
+	r := newTestReconciler(t, als, pod0, pod1)
+
+	for i := 0; i != 3; i++ {
+		stored := als.Status.DeepCopy()
+		_, err := r.syncPods(als)
+		if err := r.syncStatus(als, stored, err); err != nil {
+			t.Fatalf("Failed to sync status, since: %v", err)
+		}
+	}
+	if count := countEvents(r, ReasonScaleDownBlocked); count != 1 {
+		t.Errorf("expected 1 %s event when scaling down gets blocked, got %d", ReasonScaleDownBlocked, count)
+	}
+	if cond := getCondition(&als.Status, alcorv1alpha1.AlcorSetProgressing); cond == nil || cond.Reason != ReasonScaleDownBlocked {
+		t.Errorf("expected Progressing condition with reason %s, got %v", ReasonScaleDownBlocked, cond)
+	}
+
+	// pod 0 is ready, pod 1 is deleted
+	ready := getPodMap(listTestPods(t, r, als))[getPodName(als, 0)]
+	ready.Status.Phase = corev1.PodRunning
+	ready.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	if err := r.client.Status().Update(context.TODO(), &ready); err != nil {
+		t.Fatal(err)
+	}
+	stored := als.Status.DeepCopy()
+	_, err := r.syncPods(als)
+	if err := r.syncStatus(als, stored, err); err != nil {
+		t.Fatalf("Failed to sync status, since: %v", err)
+	}
+	if pods := listTestPods(t, r, als); len(pods.Items) != 1 {
+		t.Errorf("expected pod 1 deleted, got %d pods", len(pods.Items))
+	}
+	if count := countEvents(r, ReasonScaleDownBlocked); count != 0 {
+		t.Errorf("expected no %s event after unblocked, got %d", ReasonScaleDownBlocked, count)
+	}
+}
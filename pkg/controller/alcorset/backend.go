@@ -0,0 +1,113 @@
+package alcorset
+
+import (
+	"fmt"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// claimedIP is IP claimed for pod with a certain index
+type claimedIP struct {
+	ip string
+	// name of claim object, empty for fixed IP unless it's pinned by VPCIPClaim
+	claimName string
+	// VPC NIC of VPCIPClaim
+	nicID      string
+	nicMAC     string
+	instanceID string
+	// when claim object is created, zero for fixed IP
+	createdAt metav1.Time
+}
+
+// networkBackend claims IPs for pods of AlcorSet, and tells network plugin which IP pod uses.
+// IP for pod with a certain index should be stable, until it's released.
+type networkBackend interface {
+	// Finalizer returns finalizer of AlcorSet, which is removed after claimed IPs are released
+	Finalizer() string
+	// ClaimIP starts to claim IP for pod with given index, nothing happens if IP has been claimed,
+	// or claim is being deleted, new claim will be created after it's gone
+	ClaimIP(als *alcorv1alpha1.AlcorSet, podIdx int) error
+	// GetClaimedIP returns IP claimed for pod with given index, nil means IP not ready yet.
+	// IP of claim being deleted is never returned, since it's being handed back
+	GetClaimedIP(als *alcorv1alpha1.AlcorSet, podIdx int) (*claimedIP, error)
+	// PodAnnotations returns annotations for network plugin to setup pod with claimed IP
+	PodAnnotations(als *alcorv1alpha1.AlcorSet, claimed *claimedIP) (map[string]string, error)
+	// PodIP returns IP which pod is setup with, read from annotations returned by PodAnnotations,
+	// empty if not found
+	PodIP(pod *corev1.Pod) string
+	// Release releases all IPs claimed for AlcorSet, and returns released IPs
+	Release(als *alcorv1alpha1.AlcorSet) ([]string, error)
+	// ListClaimedIPs returns claims of AlcorSet keyed by pod index, ip is empty if claim is not ready yet.
+	// Fixed IPs are not included, since they are never claimed by AlcorSet, even if pinned by VPCIPClaims
+	ListClaimedIPs(als *alcorv1alpha1.AlcorSet) (map[int]*claimedIP, error)
+	// ReleaseIP releases IP claimed for pod with given index, and returns released IP. Orphaned claims are kept
+	ReleaseIP(als *alcorv1alpha1.AlcorSet, podIdx int) (string, error)
+	// Orphan leaves claims of AlcorSet behind with owner reference removed and labeled as orphan,
+	// and returns orphaned IPs
+	Orphan(als *alcorv1alpha1.AlcorSet) ([]string, error)
+	// Adopt takes orphaned claims left by AlcorSet with the same name, and returns adopted IPs
+	Adopt(als *alcorv1alpha1.AlcorSet) ([]string, error)
+}
+
+// backendFactories are network backends keyed by spec.networkBackend, new backends register here
+var backendFactories = map[string]func(client.Client) networkBackend{
+	VPCBackend:    newVPCBackend,
+	SriovBackend:  newSriovBackend,
+	CalicoBackend: newCalicoBackend,
+}
+
+func (r *ReconcileAlcorSet) getBackend(als *alcorv1alpha1.AlcorSet) (networkBackend, error) {
+	name := getNetworkBackend(als)
+	factory, ok := backendFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown network backend %s", name)
+	}
+	return factory(r.client), nil
+}
+
+// isOrphaned returns whether claim is left by deleted AlcorSet and not adopted yet. Orphaned claims are
+// never used or released, until they are adopted, which removes orphan label after ippool is checked.
+func isOrphaned(obj metav1.Object) bool {
+	return obj.GetLabels()[AlcorSetOrphanLabel] == "true"
+}
+
+// orphanObject removes owner reference of AlcorSet from claim and labels it as orphan,
+// returns whether claim is changed
+func orphanObject(obj metav1.Object, als *alcorv1alpha1.AlcorSet) bool {
+	if obj.GetDeletionTimestamp() != nil || isOrphaned(obj) {
+		return false
+	}
+	ownerRefs := []metav1.OwnerReference{}
+	for _, ownerRef := range obj.GetOwnerReferences() {
+		if ownerRef.UID != als.UID {
+			ownerRefs = append(ownerRefs, ownerRef)
+		}
+	}
+	obj.SetOwnerReferences(ownerRefs)
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[AlcorSetOrphanLabel] = "true"
+	obj.SetLabels(labels)
+	return true
+}
+
+// adoptObject sets AlcorSet as controller of orphaned claim named after its pod,
+// returns whether claim is adopted
+func adoptObject(obj metav1.Object, als *alcorv1alpha1.AlcorSet) bool {
+	if obj.GetDeletionTimestamp() != nil || metav1.GetControllerOf(obj) != nil {
+		return false
+	}
+	if obj.GetName() != getPodName(als, getIndexByName(obj.GetName())) {
+		return false
+	}
+	obj.SetOwnerReferences(append(obj.GetOwnerReferences(), *newOwnerReference(als)))
+	labels := obj.GetLabels()
+	delete(labels, AlcorSetOrphanLabel)
+	obj.SetLabels(labels)
+	return true
+}
@@ -0,0 +1,37 @@
+package alcorset
+
+import (
+	"encoding/json"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// calicoBackend pins claimed or fixed IP via calico annotation
+type calicoBackend struct {
+	ipClaimBackend
+}
+
+func newCalicoBackend(c client.Client) networkBackend {
+	return &calicoBackend{ipClaimBackend{client: c}}
+}
+
+// PodAnnotations returns calico annotation, which is a json list of IPs
+func (b *calicoBackend) PodAnnotations(als *alcorv1alpha1.AlcorSet, claimed *claimedIP) (map[string]string, error) {
+	ipAddrs, err := json.Marshal([]string{claimed.ip})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		CalicoAnnotationKey: string(ipAddrs),
+	}, nil
+}
+
+func (b *calicoBackend) PodIP(pod *corev1.Pod) string {
+	ipAddrs := []string{}
+	if err := json.Unmarshal([]byte(pod.Annotations[CalicoAnnotationKey]), &ipAddrs); err != nil || len(ipAddrs) == 0 {
+		return ""
+	}
+	return ipAddrs[0]
+}
@@ -0,0 +1,52 @@
+package alcorset
+
+import (
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Event reasons, events are recorded on AlcorSet, and pod if it's affected
+const (
+	// ReasonIPClaimed is event reason for IP claimed by IPClaim or VPCIPClaim for pod
+	ReasonIPClaimed = "IPClaimed"
+	// ReasonIPClaimFailed is event reason for failing to create IPClaim or VPCIPClaim
+	ReasonIPClaimFailed = "IPClaimFailed"
+	// ReasonIPsReleased is event reason for IPClaims or VPCIPClaims are deleted
+	ReasonIPsReleased = "IPsReleased"
+	// ReasonIPReleaseFailed is event reason for failing to delete IPClaims or VPCIPClaims
+	ReasonIPReleaseFailed = "IPReleaseFailed"
+	// ReasonIPsOrphaned is event reason for IPClaims or VPCIPClaims left behind when AlcorSet is deleted
+	ReasonIPsOrphaned = "IPsOrphaned"
+	// ReasonIPsAdopted is event reason for orphaned IPClaims or VPCIPClaims adopted
+	ReasonIPsAdopted = "IPsAdopted"
+	// ReasonFinalizerReleased is event reason for finalizer removed from AlcorSet
+	ReasonFinalizerReleased = "FinalizerReleased"
+	// ReasonPodCreated is event reason for pod created
+	ReasonPodCreated = "PodCreated"
+	// ReasonPodCreateFailed is event reason for failing to create pod
+	ReasonPodCreateFailed = "PodCreateFailed"
+	// ReasonPodDeleted is event reason for pod deleted for scaling down, rolling update or AlcorSet deleted
+	ReasonPodDeleted = "PodDeleted"
+	// ReasonPodDeleteFailed is event reason for failing to delete pod
+	ReasonPodDeleteFailed = "PodDeleteFailed"
+	// ReasonPodMissing is event reason for pod bound to IP disappeared without being deleted by AlcorSet
+	ReasonPodMissing = "PodMissing"
+	// ReasonPodForceDeleted is event reason for pod on unreachable node is force deleted
+	ReasonPodForceDeleted = "PodForceDeleted"
+	// ReasonPodRestarted is event reason for failed or succeeded pod is deleted and to be recreated
+	ReasonPodRestarted = "PodRestarted"
+	// ReasonScaleDownBlocked is event reason for pods cannot be deleted in sequence case until others are ready
+	ReasonScaleDownBlocked = "ScaleDownBlocked"
+	// ReasonStagePromoted is event reason for stagePodSpec replacing template
+	ReasonStagePromoted = "StagePromoted"
+	// ReasonLeakedClaim is event reason for IPClaim or VPCIPClaim not used by AlcorSet any more
+	ReasonLeakedClaim = "LeakedClaim"
+	// ReasonLeakedClaimReleased is event reason for leaked IPClaim or VPCIPClaim released
+	ReasonLeakedClaimReleased = "LeakedClaimReleased"
+)
+
+// recordPodEvent records event on both AlcorSet and pod
+func (r *ReconcileAlcorSet) recordPodEvent(als *alcorv1alpha1.AlcorSet, pod *corev1.Pod, eventType, reason, messageFmt string, args ...interface{}) {
+	r.recorder.Eventf(als, eventType, reason, messageFmt, args...)
+	r.recorder.Eventf(pod, eventType, reason, messageFmt, args...)
+}
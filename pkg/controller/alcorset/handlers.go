@@ -3,73 +3,19 @@ package alcorset
 import (
 	"context"
 	"fmt"
-	"log"
+	"strings"
+	"time"
 
 	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
-	ipclaim "github.com/onionpiece/ipclaim/pkg/apis/alcor/v1alpha1"
-	saishang "github.com/onionpiece/saishang/pkg/types"
-	"github.com/onionpiece/vpcapi"
-	vpcipclaim "github.com/onionpiece/vpcipclaim/pkg/apis/alcor/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
-func (r *ReconcileAlcorSet) initStatus(als *alcorv1alpha1.AlcorSet) error {
-	doInit := false
-	alsStatus := als.Status.DeepCopy()
-	if alsStatus.ClaimedIPs == nil {
-		alsStatus.ClaimedIPs = []string{}
-		doInit = true
-	}
-	if doInit {
-		als.Status = *alsStatus
-		if err := r.client.Status().Update(context.TODO(), als); err != nil {
-			return fmt.Errorf("Failed to update status for psHash, since: %v", err)
-		}
-	}
-	return nil
-}
-
-func (r *ReconcileAlcorSet) getIPClaimRef(als *alcorv1alpha1.AlcorSet, podName string) (*ipclaim.IPClaim, error) {
-	ipClaimRef := &ipclaim.IPClaim{}
-	err := r.client.Get(context.TODO(), types.NamespacedName{Name: podName, Namespace: als.Namespace}, ipClaimRef)
-	if err != nil && errors.IsNotFound(err) {
-		log.Printf("Creating a new IPClaim for %s.%s on %s", als.Namespace, podName, als.Spec.IPPool)
-		newIPClaim := newIPClaimForCR(als, podName)
-		if err := r.client.Create(context.TODO(), newIPClaim); err != nil {
-			return nil, fmt.Errorf("Fail to create ipclaim")
-		}
-		return nil, nil
-	}
-	if ipClaimRef.Status.IP == "" {
-		return nil, nil
-	}
-	log.Printf("Get vpcipclaim(%v) for alcorset(%s.%s)", ipClaimRef.Status, als.Namespace, podName)
-	return ipClaimRef, nil
-}
-
-func (r *ReconcileAlcorSet) getVPCIPClaimRef(als *alcorv1alpha1.AlcorSet, podName string) (*vpcipclaim.VPCIPClaim, error) {
-	vpcIPClaimRef := &vpcipclaim.VPCIPClaim{}
-	err := r.client.Get(context.TODO(), types.NamespacedName{Name: podName, Namespace: als.Namespace}, vpcIPClaimRef)
-	if err != nil && errors.IsNotFound(err) {
-		log.Printf("Creating a new VPCIPClaim for %s.%s", als.Namespace, podName)
-		newVPCIPClaim := newVPCIPClaimForCR(als, podName)
-		if err := r.client.Create(context.TODO(), newVPCIPClaim); err != nil {
-			return nil, fmt.Errorf("Fail to create vpcipclaim")
-		}
-		return nil, nil
-	}
-	if vpcIPClaimRef.Status.IP == "" {
-		return nil, nil
-	}
-	log.Printf("Get vpcipclaim(%v) for alcorset(%s.%s)", vpcIPClaimRef.Status, als.Namespace, podName)
-	return vpcIPClaimRef, nil
-}
-
 func (r *ReconcileAlcorSet) addFinalizers(alcorset *alcorv1alpha1.AlcorSet, toAdd []string) error {
 	fins := alcorset.GetFinalizers()
 	fins = append(fins, toAdd...)
@@ -93,19 +39,48 @@ func (r *ReconcileAlcorSet) removeFinalizer(alcorset *alcorv1alpha1.AlcorSet, to
 	if err != nil {
 		return err
 	}
+	r.recorder.Eventf(alcorset, corev1.EventTypeNormal, ReasonFinalizerReleased, "Finalizer %s removed", toRemove)
 	return nil
 }
 
-func (r *ReconcileAlcorSet) getPods(alcorset *alcorv1alpha1.AlcorSet) (*corev1.PodList, error) {
+// listPods returns all pods of AlcorSet, including terminating ones
+func (r *ReconcileAlcorSet) listPods(als *alcorv1alpha1.AlcorSet) (*corev1.PodList, error) {
 	pods := &corev1.PodList{}
 	opts := []client.ListOption{
-		client.InNamespace(alcorset.Namespace),
-		client.MatchingLabels{AlcorSetAppLabel: alcorset.Name},
+		client.InNamespace(als.Namespace),
+		client.MatchingLabels{AlcorSetAppLabel: als.Name},
 	}
 	if err := r.client.List(context.TODO(), pods, opts...); err != nil {
 		return pods, err
 	}
-	if alcorset.Spec.Sequence {
+	return pods, nil
+}
+
+// podsOrphaned returns whether pods are orphaned since AlcorSet is deleted with propagationPolicy Orphan.
+// Garbage collector removes orphan finalizer once owner references are removed from pods, so pods
+// not controlled by AlcorSet are checked as well.
+func (r *ReconcileAlcorSet) podsOrphaned(als *alcorv1alpha1.AlcorSet) (bool, error) {
+	if contains(als.GetFinalizers(), metav1.FinalizerOrphanDependents) {
+		return true, nil
+	}
+	pods, err := r.listPods(als)
+	if err != nil {
+		return false, err
+	}
+	for i := range pods.Items {
+		if !metav1.IsControlledBy(&pods.Items[i], als) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *ReconcileAlcorSet) getPods(alcorset *alcorv1alpha1.AlcorSet) (*corev1.PodList, error) {
+	pods, err := r.listPods(alcorset)
+	if err != nil {
+		return pods, err
+	}
+	if inSequence(alcorset) {
 		allRunningAndReady := true
 		for _, pod := range pods.Items {
 			if pod.DeletionTimestamp != nil {
@@ -124,18 +99,22 @@ func (r *ReconcileAlcorSet) getPods(alcorset *alcorv1alpha1.AlcorSet) (*corev1.P
 }
 
 func (r *ReconcileAlcorSet) tearDownPods(als *alcorv1alpha1.AlcorSet, pods *corev1.PodList, deleteAll bool) error {
-	if als.Spec.Sequence {
+	why := "scaling down"
+	if deleteAll {
+		why = "AlcorSet deleted"
+	}
+	if inSequence(als) {
 		var pop *corev1.Pod
 		index := -1
 		// find pod with biggest index to pop
-		for _, pod := range pods.Items {
+		for i, pod := range pods.Items {
 			_index := getIndexByName(pod.Name)
 			if _index > index {
 				index = _index
-				pop = &pod
+				pop = &pods.Items[i]
 			}
 		}
-		return r.client.Delete(context.TODO(), pop)
+		return r.deletePod(als, pop, why)
 	}
 	border := als.Spec.Replicas
 	if deleteAll {
@@ -143,7 +122,7 @@ func (r *ReconcileAlcorSet) tearDownPods(als *alcorv1alpha1.AlcorSet, pods *core
 	}
 	for _, pod := range pods.Items {
 		if getIndexByName(pod.Name) >= border {
-			if err := r.client.Delete(context.TODO(), &pod); err != nil {
+			if err := r.deletePod(als, &pod, why); err != nil {
 				return err
 			}
 		}
@@ -151,76 +130,79 @@ func (r *ReconcileAlcorSet) tearDownPods(als *alcorv1alpha1.AlcorSet, pods *core
 	return nil
 }
 
-func (r *ReconcileAlcorSet) createPod(als *alcorv1alpha1.AlcorSet, pods *corev1.PodList) (bool, error) {
-	inStage := false
-	podMap := getPodMap(pods)
-	numToCreate := 1
-	if !als.Spec.Sequence {
-		numToCreate = als.Spec.Replicas - len(pods.Items)
-	}
-	for i := 0; i != numToCreate; i++ {
-		podName := ""
-		podHostname := ""
-		for idx := 0; idx != als.Spec.Replicas; idx++ {
-			podName = getPodName(als, idx)
-			podHostname = getPodHostname(als, idx)
-			if _, ok := podMap[podName]; !ok {
-				break
-			}
+// deletePod deletes pod and records event about why it's deleted
+func (r *ReconcileAlcorSet) deletePod(als *alcorv1alpha1.AlcorSet, pod *corev1.Pod, why string) error {
+	if pod.DeletionTimestamp != nil {
+		return nil
+	}
+	if err := r.client.Delete(context.TODO(), pod); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
 		}
+		r.recordPodEvent(als, pod, corev1.EventTypeWarning, ReasonPodDeleteFailed, "Failed to delete pod %s for %s: %v", pod.Name, why, err)
+		return err
+	}
+	r.recordPodEvent(als, pod, corev1.EventTypeNormal, ReasonPodDeleted, "Deleted pod %s for %s", pod.Name, why)
+	return nil
+}
 
-		annotations := make(map[string]string)
-		// Verify IPClaim or VPCIPClaim already exists
-		if als.Spec.OnVPC {
-			vpcIPClaimRef, err := r.getVPCIPClaimRef(als, podName)
-			if err != nil {
-				log.Printf("Failed to create VPCIPClaim for %s.%s, since: %v", als.Namespace, als.Name, err)
-				return true, nil
-			} else if vpcIPClaimRef == nil {
-				log.Printf("VPCIPClaim for %s.%s not ready yet, will requeue", als.Namespace, podName)
-				return true, nil
-			}
-			if vpcIPClaimRef.Status.IP != "" && !contains(als.Status.ClaimedIPs, vpcIPClaimRef.Status.IP) {
-				alsStatus := als.Status.DeepCopy()
-				alsStatus.ClaimedIPs = append(alsStatus.ClaimedIPs, vpcIPClaimRef.Status.IP)
-				als.Status = *alsStatus
-				if err := r.client.Status().Update(context.TODO(), als); err != nil {
-					return false, err
-				}
-			}
-			annotations[vpcapi.AnnoKeyVPCIP] = vpcIPClaimRef.Status.IP
-			annotations[vpcapi.AnnoKeyVPCNICMAC] = vpcIPClaimRef.Status.InterfaceMACAddress
-			annotations[vpcapi.AnnoKeyVPCNICID] = vpcIPClaimRef.Status.InterfaceID
-			annotations[vpcapi.AnnoKeyVPCInstanceID] = vpcIPClaimRef.Status.InstanceID
-			annotations[vpcapi.AnnoKeyVPCIPRetain] = "true"
-		} else {
-			ipClaimRef, err := r.getIPClaimRef(als, podName)
-			if err != nil {
-				log.Printf("Failed to create IPClaim for %s.%s, since: %v", als.Namespace, als.Name, err)
-				return true, nil
-			} else if ipClaimRef == nil {
-				log.Printf("IPClaim for %s.%s not ready yet, will requeue", als.Namespace, podName)
-				return true, nil
-			}
-			if ipClaimRef.Status.IP != "" && !contains(als.Status.ClaimedIPs, ipClaimRef.Status.IP) {
-				alsStatus := als.Status.DeepCopy()
-				alsStatus.ClaimedIPs = append(alsStatus.ClaimedIPs, ipClaimRef.Status.IP)
-				als.Status = *alsStatus
-				if err := r.client.Status().Update(context.TODO(), als); err != nil {
-					return false, err
-				}
+// createPod creates pods for missing indexes, from the smallest one. Pod is always recreated with
+// the same name, hostname and IP claim for its index, no matter it's missing at the tail or in the middle.
+// Pods whose IPs are not ready yet are skipped, and requeue is returned to create them later.
+func (r *ReconcileAlcorSet) createPod(als *alcorv1alpha1.AlcorSet, pods *corev1.PodList, missing []int) (bool, error) {
+	backend, err := r.getBackend(als)
+	if err != nil {
+		return false, err
+	}
+	numToCreate := getNumToCreate(als, pods, len(missing))
+	if numToCreate <= 0 {
+		alcorSetLogger(als).V(1).Info("Burst size reached, wait pods ready", "burstSize", als.Spec.BurstSize)
+		return false, nil
+	}
+	toCreate := missing[:numToCreate]
+	// claim IPs for all pods to create first, so IPs are claimed in parallel
+	for _, podIdx := range toCreate {
+		if err := backend.ClaimIP(als, podIdx); err != nil {
+			podName := getPodName(als, podIdx)
+			r.recorder.Eventf(als, corev1.EventTypeWarning, ReasonIPClaimFailed, "Failed to claim IP for pod %s: %v", podName, err)
+			return false, &ipClaimError{podName: podName, err: err}
+		}
+	}
+	requeue := false
+	for _, podIdx := range toCreate {
+		podName := getPodName(als, podIdx)
+		podHostname := getPodHostname(als, podIdx)
+		podLogger := alcorSetLogger(als).WithValues("ordinal", podIdx, "pod", podName)
+
+		claimed, err := backend.GetClaimedIP(als, podIdx)
+		if err != nil {
+			return false, err
+		} else if claimed == nil {
+			podLogger.Info("IP not ready yet, will requeue")
+			requeue = true
+			continue
+		}
+		if claimed.claimName != "" && len(als.Spec.IPs) == 0 && !contains(als.Status.ClaimedIPs, claimed.ip) {
+			// not in last status snapshot, claimed since last reconcile, pinned claims are never listed
+			r.recorder.Eventf(als, corev1.EventTypeNormal, ReasonIPClaimed, "IP %s claimed by %s for pod %s", claimed.ip, claimed.claimName, podName)
+			if !claimed.createdAt.IsZero() {
+				ipClaimDuration.WithLabelValues(getNetworkBackend(als)).Observe(time.Since(claimed.createdAt.Time).Seconds())
 			}
-			// TODO
-			annotations[saishang.AnnoKeySriovIP] = ipClaimRef.Status.IP
-			annotations[saishang.AnnoKeySriovVlan] = ""
-			annotations[saishang.AnnoKeySriovRoute] = ""
-			annotations[saishang.AnnoKeySriovMask] = ""
-			annotations[saishang.AnnoKeySriovMbps] = ""
 		}
-		log.Printf("Going to use annotations: %v", annotations)
+		annotations, err := backend.PodAnnotations(als, claimed)
+		if err != nil {
+			r.recorder.Eventf(als, corev1.EventTypeWarning, ReasonPodCreateFailed, "Failed to create pod %s: %v", podName, err)
+			return false, err
+		}
+		podLogger.V(1).Info("Going to use annotations", "annotations", annotations)
+
+		// PVCs should exist before pod is created
+		if err := r.ensurePVCs(als, podIdx); err != nil {
+			return false, err
+		}
 
 		// Define a new Pod object
-		pod := newPodForCR(als, podName, podHostname, inStage, annotations)
+		pod := newPodForCR(als, podName, podHostname, inStage(als, podIdx), annotations)
 
 		// Set als instance as the owner and controller
 		if err := controllerutil.SetControllerReference(als, pod, r.scheme); err != nil {
@@ -228,98 +210,194 @@ func (r *ReconcileAlcorSet) createPod(als *alcorv1alpha1.AlcorSet, pods *corev1.
 		}
 		// Check if this Pod already exists
 		found := &corev1.Pod{}
-		err := r.client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, found)
+		err = r.client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, found)
 		if err != nil && errors.IsNotFound(err) {
-			log.Print("Creating a new Pod", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
-			if err := r.client.Create(context.TODO(), pod); err != nil {
-				return false, err
+			podLogger.Info("Creating a new Pod", "ip", claimed.ip)
+			if _, ok := als.Status.PodIPs[podName]; ok {
+				// pod was bound to IP when status was synced last time, and it's not deleted by AlcorSet,
+				// e.g. evicted or its node is gone
+				r.recorder.Eventf(als, corev1.EventTypeWarning, ReasonPodMissing,
+					"Pod %s for index %d is missing, recreating it with hostname %s and IP %s%s",
+					podName, podIdx, podHostname, claimed.ip, getLastNodeMessage(als, podIdx))
 			}
-			alsStatus := *als.Status.DeepCopy()
-			alsStatus.Count++
-			als.Status = alsStatus
-			if err := r.client.Status().Update(context.TODO(), als); err != nil {
+			if err := r.client.Create(context.TODO(), pod); err != nil {
+				r.recorder.Eventf(als, corev1.EventTypeWarning, ReasonPodCreateFailed, "Failed to create pod %s: %v", podName, err)
 				return false, err
 			}
-			podMap[podName] = *pod
+			r.recordPodEvent(als, pod, corev1.EventTypeNormal, ReasonPodCreated, "Created pod %s with hostname %s and IP %s", podName, podHostname, claimed.ip)
 		} else {
-			log.Print("Found a pod", "Name", found.Name, "Status", found.Status.Phase)
+			podLogger.V(1).Info("Found a pod", "phase", found.Status.Phase)
 		}
 	}
-	return false, nil
+	return requeue, nil
 }
 
-func (r *ReconcileAlcorSet) deleteIPClaims(als *alcorv1alpha1.AlcorSet) error {
-	ipclaims := &ipclaim.IPClaimList{}
-	opts := []client.ListOption{
-		client.InNamespace(als.Namespace),
-		client.MatchingLabels{AlcorSetAppLabel: als.Name},
-	}
-	if err := r.client.List(context.TODO(), ipclaims, opts...); err != nil {
-		if errors.IsNotFound(err) {
-			log.Print("No ipclaims found, consider the resources are deleted")
-			return nil
+// getNumToCreate returns number of missing pods can be created in this round, according to podManagementPolicy.
+// For Burst, pods pending or running but not ready yet are counted as starting, failed or succeeded pods
+// waiting restart backoff are not, and no more than burstSize pods can be starting at the same time,
+// burstSize less than 1 is treated as 1.
+func getNumToCreate(als *alcorv1alpha1.AlcorSet, pods *corev1.PodList, missing int) int {
+	switch getPodManagementPolicy(als) {
+	case alcorv1alpha1.OrderedReadyPodManagement:
+		return 1
+	case alcorv1alpha1.BurstPodManagement:
+		burstSize := als.Spec.BurstSize
+		if burstSize < 1 {
+			burstSize = 1
 		}
-		return fmt.Errorf("Failed to release ipclaims, found error when list ipclaims: %v", err)
-	}
-	claimedIPs := []string{}
-	for _, ipclaim := range ipclaims.Items {
-		claimedIPs = append(claimedIPs, ipclaim.Status.IP)
-		if err := r.client.Delete(context.TODO(), &ipclaim); err != nil {
-			return fmt.Errorf("Failed to release ipclaims, found error when delete ipclaim: %v", err)
+		starting := 0
+		for _, pod := range pods.Items {
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			if pod.Status.Phase == corev1.PodPending || (pod.Status.Phase == corev1.PodRunning && !podutil.IsPodReady(&pod)) {
+				starting++
+			}
+		}
+		if burstSize-starting < missing {
+			return burstSize - starting
 		}
 	}
+	return missing
+}
 
-	ipLeft := []string{}
-	for _, ip := range als.Status.ClaimedIPs {
-		if contains(claimedIPs, ip) {
-			ipLeft = append(ipLeft, ip)
-		}
+// promoteStage replaces template with stagePodSpec once stageReplicas covers all replicas.
+// Pods created by stagePodSpec will not be recreated, since they have the same spec hash as new template.
+// Nothing is promoted with no replicas, stagePodSpec covers no pod then.
+func (r *ReconcileAlcorSet) promoteStage(als *alcorv1alpha1.AlcorSet) (bool, error) {
+	if als.Spec.StagePodTemplateSpec == nil || als.Spec.Replicas == 0 || als.Spec.StageReplicas < als.Spec.Replicas {
+		return false, nil
 	}
+	alcorSetLogger(als).Info("Promoting stagePodSpec")
+	als.Spec.PodTemplateSpec = *als.Spec.StagePodTemplateSpec
+	als.Spec.StagePodTemplateSpec = nil
+	als.Spec.StageReplicas = 0
+	// status returned by update is the stored one, keep what is recorded in this reconcile
 	alsStatus := als.Status.DeepCopy()
-	alsStatus.ClaimedIPs = ipLeft
-	als.Status = *alsStatus
-	if err := r.client.Status().Update(context.TODO(), als); err != nil {
-		return err
+	if err := r.client.Update(context.TODO(), als); err != nil {
+		return false, fmt.Errorf("Failed to promote stagePodSpec, since: %v", err)
 	}
-	return nil
+	als.Status = *alsStatus
+	r.recorder.Event(als, corev1.EventTypeNormal, ReasonStagePromoted, "stagePodSpec promoted as template")
+	return true, nil
 }
 
-func (r *ReconcileAlcorSet) deleteVPCIPClaims(als *alcorv1alpha1.AlcorSet) error {
-	log.Printf("Deleting VPCIPClaim for %s.%s", als.Namespace, als.Name)
-	vpcipclaims := &vpcipclaim.VPCIPClaimList{}
-	opts := []client.ListOption{
-		client.InNamespace(als.Namespace),
-		client.MatchingLabels{AlcorSetAppLabel: als.Name},
+// rollingUpdate deletes pods not created by the pod template expected for their index, from the
+// biggest index, deleted pods will be recreated later with the same name, hostname and IP claim.
+// Out of date pods not ready are deleted first, since they are unavailable already, e.g. created by
+// a broken template. No more than maxUnavailable pods will be unavailable at the same time.
+func (r *ReconcileAlcorSet) rollingUpdate(als *alcorv1alpha1.AlcorSet, pods *corev1.PodList) (bool, error) {
+	if err := r.backfillSpecLabels(als, pods); err != nil {
+		return false, err
 	}
-	if err := r.client.List(context.TODO(), vpcipclaims, opts...); err != nil {
-		if errors.IsNotFound(err) {
-			log.Print("No vpcipclaims found, consider the resources are deleted")
-			return nil
+	unavailable := als.Spec.Replicas - len(pods.Items)
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || !podutil.IsPodReady(&pod) {
+			unavailable++
 		}
-		return fmt.Errorf("Failed to release vpcipclaims, found error when list vpcipclaims: %v", err)
-	}
-	claimedIPs := []string{}
-	for _, vpcipclaim := range vpcipclaims.Items {
-		claimedIPs = append(claimedIPs, vpcipclaim.Status.IP)
-		log.Printf("To delete vpcipclaim %s", vpcipclaim.Name)
-		if err := r.client.Delete(context.TODO(), &vpcipclaim); err != nil {
-			return fmt.Errorf("Failed to release vpcipclaims, found error when delete vpcipclaim: %v", err)
+	}
+	toDelete := getMaxUnavailable(als) - unavailable
+	podMap := getPodMap(pods)
+	deleted := []string{}
+	for _, ready := range []bool{false, true} {
+		for idx := als.Spec.Replicas - 1; idx >= 0; idx-- {
+			pod, ok := podMap[getPodName(als, idx)]
+			if !ok || pod.DeletionTimestamp != nil || podutil.IsPodReady(&pod) != ready {
+				continue
+			}
+			if pod.Labels[AlcorSetSpecLabel] == getTemplateHash(als, getPodTemplate(als, idx)) {
+				continue
+			}
+			if ready {
+				if toDelete <= 0 {
+					break
+				}
+				toDelete--
+			}
+			alcorSetLogger(als).Info("Pod is out of date, going to recreate it", "ordinal", idx, "pod", pod.Name, "ready", ready)
+			if err := r.deletePod(als, &pod, "rolling update"); err != nil {
+				return false, err
+			}
+			deleted = append(deleted, pod.Name)
 		}
 	}
+	return len(deleted) > 0, nil
+}
 
-	ipLeft := []string{}
-	for _, ip := range als.Status.ClaimedIPs {
-		if contains(claimedIPs, ip) {
-			ipLeft = append(ipLeft, ip)
+// backfillSpecLabels labels pods created before spec label is introduced with hash of the pod template
+// expected for their index, so they are not recreated for upgrading operator.
+func (r *ReconcileAlcorSet) backfillSpecLabels(als *alcorv1alpha1.AlcorSet, pods *corev1.PodList) error {
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil || pod.Labels[AlcorSetSpecLabel] != "" {
+			continue
 		}
-	}
-	if len(ipLeft) < len(als.Status.ClaimedIPs) {
-		alsStatus := als.Status.DeepCopy()
-		alsStatus.ClaimedIPs = ipLeft
-		als.Status = *alsStatus
-		if err := r.client.Status().Update(context.TODO(), als); err != nil {
-			return err
+		pod.Labels[AlcorSetSpecLabel] = getTemplateHash(als, getPodTemplate(als, getIndexByName(pod.Name)))
+		alcorSetLogger(als).Info("Backfilling spec label of pod", "pod", pod.Name, "hash", pod.Labels[AlcorSetSpecLabel])
+		if err := r.client.Update(context.TODO(), pod); err != nil {
+			return fmt.Errorf("Failed to backfill spec label of pod %s, since: %v", pod.Name, err)
 		}
 	}
 	return nil
 }
+
+// releaseIPs releases IPs claimed by backend
+func (r *ReconcileAlcorSet) releaseIPs(als *alcorv1alpha1.AlcorSet, backend networkBackend) error {
+	releasedIPs, err := backend.Release(als)
+	if err != nil {
+		r.recorder.Eventf(als, corev1.EventTypeWarning, ReasonIPReleaseFailed, "Failed to release IPs: %v", err)
+		ipReleaseFailures.WithLabelValues(getNetworkBackend(als)).Inc()
+	}
+	if len(releasedIPs) > 0 {
+		r.recorder.Eventf(als, corev1.EventTypeNormal, ReasonIPsReleased, "Released IPs: %s", strings.Join(releasedIPs, ","))
+	}
+	return err
+}
+
+// releaseIdleIPs releases IPs claimed for indexes no less than replicas by ipRetentionPolicy Release,
+// returns duration to check again for quarantine
+func (r *ReconcileAlcorSet) releaseIdleIPs(als *alcorv1alpha1.AlcorSet) (time.Duration, error) {
+	if getIPRetentionPolicy(als) != alcorv1alpha1.ReleaseIPRetentionPolicy {
+		return 0, nil
+	}
+	backend, err := r.getBackend(als)
+	if err != nil {
+		return 0, err
+	}
+	pods, err := r.listPods(als)
+	if err != nil {
+		return 0, err
+	}
+	_, requeueAfter, err := r.tearDownIPs(als, pods, backend, als.Spec.Replicas)
+	return requeueAfter, err
+}
+
+// adoptIPs adopts claims orphaned by deleted AlcorSet with the same name, if spec.ipAdoption is true
+func (r *ReconcileAlcorSet) adoptIPs(als *alcorv1alpha1.AlcorSet) error {
+	if !als.Spec.IPAdoption {
+		return nil
+	}
+	backend, err := r.getBackend(als)
+	if err != nil {
+		return err
+	}
+	adoptedIPs, err := backend.Adopt(als)
+	if len(adoptedIPs) > 0 {
+		alcorSetLogger(als).Info("Adopted orphaned IPs", "ips", adoptedIPs)
+		r.recorder.Eventf(als, corev1.EventTypeNormal, ReasonIPsAdopted, "Adopted IPs: %s", strings.Join(adoptedIPs, ","))
+	}
+	return err
+}
+
+// orphanIPs leaves claims behind for AlcorSet created later with the same name
+func (r *ReconcileAlcorSet) orphanIPs(als *alcorv1alpha1.AlcorSet, backend networkBackend) error {
+	orphanedIPs, err := backend.Orphan(als)
+	if err != nil {
+		return err
+	}
+	if len(orphanedIPs) > 0 {
+		alcorSetLogger(als).Info("Orphaned IPs", "ips", orphanedIPs)
+		r.recorder.Eventf(als, corev1.EventTypeNormal, ReasonIPsOrphaned, "Orphaned IPs: %s", strings.Join(orphanedIPs, ","))
+	}
+	return nil
+}
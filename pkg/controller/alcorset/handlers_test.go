@@ -0,0 +1,413 @@
+package alcorset
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestCreatePod(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    alcorv1alpha1.PodManagementPolicyType
+		burstSize int
+		ready     []int
+		notReady  []int
+		// indexes whose claims have no IP yet
+		pendingIPs []int
+		created    []int
+		requeue    bool
+	}{
+		{name: "Parallel, gap at head", policy: alcorv1alpha1.ParallelPodManagement,
+			ready: []int{1, 2, 3, 4}, created: []int{0}},
+		{name: "Parallel, gaps in middle", policy: alcorv1alpha1.ParallelPodManagement,
+			ready: []int{0, 2, 4}, created: []int{1, 3}},
+		{name: "Parallel, gap at tail", policy: alcorv1alpha1.ParallelPodManagement,
+			ready: []int{0, 1, 2}, created: []int{3, 4}},
+		{name: "Parallel, IP of one gap not ready", policy: alcorv1alpha1.ParallelPodManagement,
+			ready: []int{0, 2, 4}, pendingIPs: []int{1}, created: []int{3}, requeue: true},
+		{name: "OrderedReady, gap at head", policy: alcorv1alpha1.OrderedReadyPodManagement,
+			ready: []int{1, 2, 3, 4}, created: []int{0}},
+		{name: "OrderedReady, gaps in middle", policy: alcorv1alpha1.OrderedReadyPodManagement,
+			ready: []int{0, 2, 4}, created: []int{1}},
+		{name: "OrderedReady, gap at tail", policy: alcorv1alpha1.OrderedReadyPodManagement,
+			ready: []int{0, 1, 2}, created: []int{3}},
+		{name: "Burst, gaps at head and tail", policy: alcorv1alpha1.BurstPodManagement, burstSize: 2,
+			ready: []int{1, 2, 3}, created: []int{0, 4}},
+		{name: "Burst, gaps everywhere", policy: alcorv1alpha1.BurstPodManagement, burstSize: 2,
+			ready: []int{1, 3}, created: []int{0, 2}},
+		{name: "Burst, one pod starting", policy: alcorv1alpha1.BurstPodManagement, burstSize: 2,
+			ready: []int{1, 2}, notReady: []int{0}, created: []int{3}},
+		{name: "Burst, burst size reached", policy: alcorv1alpha1.BurstPodManagement, burstSize: 2,
+			ready: []int{2}, notReady: []int{0, 1}, created: []int{}},
+		{name: "Burst, burst size not set", policy: alcorv1alpha1.BurstPodManagement,
+			ready: []int{1, 3}, created: []int{0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(5)
+			als.Spec.PodManagementPolicy = test.policy
+			als.Spec.BurstSize = test.burstSize
+			objs := []runtime.Object{als}
+			existing := map[int]bool{}
+			for _, idx := range test.ready {
+				objs = append(objs, newTestPod(als, idx, testIP(idx), true))
+				existing[idx] = true
+			}
+			for _, idx := range test.notReady {
+				objs = append(objs, newTestPod(als, idx, testIP(idx), false))
+				existing[idx] = true
+			}
+			for idx := 0; idx != als.Spec.Replicas; idx++ {
+				ip := testIP(idx)
+				if containsInt(test.pendingIPs, idx) {
+					ip = ""
+				}
+				objs = append(objs, newTestIPClaim(als, idx, ip))
+			}
+			r := newTestReconciler(t, objs...)
+			pods := listTestPods(t, r, als)
+
+			requeue, err := r.createPod(als, pods, getMissingIndexes(als, pods))
+			if err != nil {
+				t.Fatalf("Failed to create pods, since: %v", err)
+			}
+			if requeue != test.requeue {
+				t.Errorf("expected requeue %v, got %v", test.requeue, requeue)
+			}
+			created := []int{}
+			for _, pod := range listTestPods(t, r, als).Items {
+				idx := getIndexByName(pod.Name)
+				if existing[idx] {
+					continue
+				}
+				created = append(created, idx)
+				if pod.Spec.Hostname != getPodHostname(als, idx) {
+					t.Errorf("expected hostname %s for pod %s, got %s", getPodHostname(als, idx), pod.Name, pod.Spec.Hostname)
+				}
+				ips := []string{}
+				if err := json.Unmarshal([]byte(pod.Annotations[CalicoAnnotationKey]), &ips); err != nil || len(ips) != 1 || ips[0] != testIP(idx) {
+					t.Errorf("expected IP %s for pod %s, got %s", testIP(idx), pod.Name, pod.Annotations[CalicoAnnotationKey])
+				}
+			}
+			sort.Ints(created)
+			if !reflect.DeepEqual(created, test.created) {
+				t.Errorf("expected pods created for indexes %v, got %v", test.created, created)
+			}
+			assertNoSharedIPs(t, r, als)
+		})
+	}
+}
+
+func TestGetNumToCreate(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    alcorv1alpha1.PodManagementPolicyType
+		burstSize int
+		notReady  int
+		// failed or succeeded pods waiting restart backoff
+		terminated int
+		missing    int
+		expected   int
+	}{
+		{name: "Parallel", policy: alcorv1alpha1.ParallelPodManagement, notReady: 2, missing: 3, expected: 3},
+		{name: "OrderedReady", policy: alcorv1alpha1.OrderedReadyPodManagement, missing: 3, expected: 1},
+		{name: "Burst, under burst size", policy: alcorv1alpha1.BurstPodManagement, burstSize: 5, missing: 3, expected: 3},
+		{name: "Burst, limited by burst size", policy: alcorv1alpha1.BurstPodManagement, burstSize: 2, missing: 3, expected: 2},
+		{name: "Burst, some starting", policy: alcorv1alpha1.BurstPodManagement, burstSize: 3, notReady: 2, missing: 3, expected: 1},
+		{name: "Burst, burst size reached", policy: alcorv1alpha1.BurstPodManagement, burstSize: 2, notReady: 2, missing: 3, expected: 0},
+		{name: "Burst, burst size not set", policy: alcorv1alpha1.BurstPodManagement, missing: 3, expected: 1},
+		{name: "Burst, pods in restart backoff not starting", policy: alcorv1alpha1.BurstPodManagement, burstSize: 2,
+			terminated: 2, missing: 3, expected: 2},
+		{name: "Burst, pods starting and in restart backoff", policy: alcorv1alpha1.BurstPodManagement, burstSize: 2,
+			notReady: 1, terminated: 2, missing: 3, expected: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(10)
+			als.Spec.PodManagementPolicy = test.policy
+			als.Spec.BurstSize = test.burstSize
+			pods := &corev1.PodList{}
+			for idx := 0; idx != test.notReady; idx++ {
+				pods.Items = append(pods.Items, *newTestPod(als, idx, testIP(idx), false))
+			}
+			for idx := test.notReady; idx != test.notReady+test.terminated; idx++ {
+				pod := newTestPod(als, idx, testIP(idx), false)
+				pod.Status.Phase = corev1.PodFailed
+				if idx%2 == 1 {
+					pod.Status.Phase = corev1.PodSucceeded
+				}
+				pods.Items = append(pods.Items, *pod)
+			}
+			idx := test.notReady + test.terminated
+			pods.Items = append(pods.Items, *newTestPod(als, idx, testIP(idx), true))
+			if num := getNumToCreate(als, pods, test.missing); num != test.expected {
+				t.Errorf("expected %d pods to create, got %d", test.expected, num)
+			}
+		})
+	}
+}
+
+func TestRollingUpdate(t *testing.T) {
+	two := intstr.FromInt(2)
+	half := intstr.FromString("50%")
+	tests := []struct {
+		name           string
+		maxUnavailable *intstr.IntOrString
+		sequence       bool
+		// pods created by the current template, others are out of date
+		updated  []int
+		notReady []int
+		missing  []int
+		// pods without spec label, created before spec label is introduced
+		unlabeled []int
+		deleted   []int
+	}{
+		{name: "maxUnavailable not set", deleted: []int{3}},
+		{name: "maxUnavailable 2", maxUnavailable: &two, deleted: []int{2, 3}},
+		{name: "maxUnavailable 50%", maxUnavailable: &half, deleted: []int{2, 3}},
+		{name: "sequence, maxUnavailable 2", maxUnavailable: &two, sequence: true, deleted: []int{3}},
+		{name: "updated pods skipped", updated: []int{2, 3}, maxUnavailable: &two, deleted: []int{0, 1}},
+		{name: "out of date pod not ready deleted first", maxUnavailable: &two, notReady: []int{1}, deleted: []int{1, 3}},
+		{name: "out of date pods not ready deleted beyond maxUnavailable", notReady: []int{0, 1}, deleted: []int{0, 1}},
+		{name: "missing pod counted as unavailable", missing: []int{3}, deleted: []int{}},
+		{name: "missing pod counted as unavailable, maxUnavailable 2", maxUnavailable: &two, missing: []int{3}, deleted: []int{2}},
+		{name: "updated pod not ready counted as unavailable", maxUnavailable: &two, updated: []int{3}, notReady: []int{3}, deleted: []int{2}},
+		{name: "unlabeled pods backfilled", unlabeled: []int{0, 1, 2, 3}, deleted: []int{}},
+		{name: "unlabeled pods backfilled, others updated", unlabeled: []int{0, 1}, deleted: []int{3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(4)
+			als.Spec.MaxUnavailable = test.maxUnavailable
+			als.Spec.Sequence = test.sequence
+			outdated := als.DeepCopy()
+			als.Spec.PodTemplateSpec.Spec.Containers[0].Image = "app:v2"
+			objs := []runtime.Object{als}
+			for idx := 0; idx != als.Spec.Replicas; idx++ {
+				if containsInt(test.missing, idx) {
+					continue
+				}
+				pod := newTestPod(outdated, idx, testIP(idx), !containsInt(test.notReady, idx))
+				if containsInt(test.updated, idx) {
+					pod = newTestPod(als, idx, testIP(idx), !containsInt(test.notReady, idx))
+				}
+				if containsInt(test.unlabeled, idx) {
+					// created by the current template before spec label is introduced
+					pod = newTestPod(als, idx, testIP(idx), !containsInt(test.notReady, idx))
+					delete(pod.Labels, AlcorSetSpecLabel)
+				}
+				objs = append(objs, pod)
+			}
+			r := newTestReconciler(t, objs...)
+
+			updating, err := r.rollingUpdate(als, listTestPods(t, r, als))
+			if err != nil {
+				t.Fatalf("Failed to roll pods, since: %v", err)
+			}
+			if updating != (len(test.deleted) > 0) {
+				t.Errorf("expected updating %v, got %v", len(test.deleted) > 0, updating)
+			}
+			deleted := []int{}
+			podMap := getPodMap(listTestPods(t, r, als))
+			for idx := 0; idx != als.Spec.Replicas; idx++ {
+				pod, ok := podMap[getPodName(als, idx)]
+				if !ok {
+					if !containsInt(test.missing, idx) {
+						deleted = append(deleted, idx)
+					}
+					continue
+				}
+				if containsInt(test.unlabeled, idx) && pod.Labels[AlcorSetSpecLabel] != getTemplateHash(als, &als.Spec.PodTemplateSpec) {
+					t.Errorf("expected spec label of pod %s backfilled, got %v", pod.Name, pod.Labels)
+				}
+			}
+			if !reflect.DeepEqual(deleted, test.deleted) {
+				t.Errorf("expected pods deleted for indexes %v, got %v", test.deleted, deleted)
+			}
+		})
+	}
+}
+
+func TestPromoteStage(t *testing.T) {
+	tests := []struct {
+		name          string
+		replicas      int
+		stageReplicas int
+		promoted      bool
+	}{
+		{name: "stage covers part of replicas", replicas: 3, stageReplicas: 2},
+		{name: "stage covers all replicas", replicas: 3, stageReplicas: 3, promoted: true},
+		{name: "stage covers more than replicas", replicas: 3, stageReplicas: 5, promoted: true},
+		{name: "no replicas", replicas: 0, stageReplicas: 0},
+		{name: "no replicas, stage replicas set", replicas: 0, stageReplicas: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(test.replicas)
+			als.Spec.StageReplicas = test.stageReplicas
+			stage := als.Spec.PodTemplateSpec.DeepCopy()
+			stage.Spec.Containers[0].Image = "app:stage"
+			als.Spec.StagePodTemplateSpec = stage
+			r := newTestReconciler(t, als)
+
+			promoted, err := r.promoteStage(als)
+			if err != nil {
+				t.Fatalf("Failed to promote stage, since: %v", err)
+			}
+			if promoted != test.promoted {
+				t.Errorf("expected promoted %v, got %v", test.promoted, promoted)
+			}
+			if promoted && (als.Spec.StagePodTemplateSpec != nil || als.Spec.PodTemplateSpec.Spec.Containers[0].Image != "app:stage") {
+				t.Errorf("expected stagePodSpec promoted as template, got %v", als.Spec)
+			}
+			if !promoted && als.Spec.StagePodTemplateSpec == nil {
+				t.Errorf("expected stagePodSpec kept")
+			}
+		})
+	}
+}
+
+// testIP returns IP claimed for pod with given index in tests
+func testIP(idx int) string {
+	return fmt.Sprintf("10.0.0.%d", idx+1)
+}
+
+func TestOrphanIPsOnDelete(t *testing.T) {
+	tests := []struct {
+		name       string
+		ipAdoption bool
+		// finalizer added by garbage collector for propagationPolicy Orphan
+		orphanFinalizer bool
+		// pods whose owner references are removed by garbage collector
+		podsOrphaned bool
+		orphaned     bool
+	}{
+		{name: "claims released"},
+		{name: "ipAdoption", ipAdoption: true, orphaned: true},
+		{name: "orphan finalizer", orphanFinalizer: true, orphaned: true},
+		{name: "pods not controlled by AlcorSet", podsOrphaned: true, orphaned: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(2)
+			als.Spec.IPAdoption = test.ipAdoption
+			now := metav1.Now()
+			als.DeletionTimestamp = &now
+			als.Finalizers = []string{FinalizerIPClaim}
+			if test.orphanFinalizer {
+				als.Finalizers = append(als.Finalizers, metav1.FinalizerOrphanDependents)
+			}
+			objs := []runtime.Object{als}
+			for idx := 0; idx != 2; idx++ {
+				objs = append(objs, newTestIPClaim(als, idx, testIP(idx)))
+				if test.orphanFinalizer || test.podsOrphaned {
+					pod := newTestPod(als, idx, testIP(idx), true)
+					if test.podsOrphaned {
+						pod.OwnerReferences = nil
+					}
+					objs = append(objs, pod)
+				}
+			}
+			r := newTestReconciler(t, objs...)
+
+			request := reconcile.Request{NamespacedName: types.NamespacedName{Name: als.Name, Namespace: als.Namespace}}
+			for i := 0; i != 3 && contains(als.Finalizers, FinalizerIPClaim); i++ {
+				if _, err := r.Reconcile(request); err != nil {
+					t.Fatalf("Failed to reconcile, since: %v", err)
+				}
+				if err := r.client.Get(context.TODO(), request.NamespacedName, als); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if contains(als.Finalizers, FinalizerIPClaim) {
+				t.Fatalf("expected finalizer %s removed, got %v", FinalizerIPClaim, als.Finalizers)
+			}
+			if orphaned := countEvents(r, ReasonIPsOrphaned) == 1; orphaned != test.orphaned {
+				t.Errorf("expected IPs orphaned %v, got %v", test.orphaned, orphaned)
+			}
+			for idx := 0; idx != 2; idx++ {
+				claim := getTestIPClaim(t, r, getPodName(als, idx))
+				if !test.orphaned {
+					if claim != nil {
+						t.Errorf("expected claim %d released, got %v", idx, claim)
+					}
+					continue
+				}
+				if claim == nil || !isOrphaned(claim) || len(claim.OwnerReferences) != 0 {
+					t.Errorf("expected claim %d orphaned without owner, got %v", idx, claim)
+				}
+			}
+			if test.orphanFinalizer || test.podsOrphaned {
+				if pods := listTestPods(t, r, als); len(pods.Items) != 2 {
+					t.Errorf("expected orphaned pods kept, got %d", len(pods.Items))
+				}
+			}
+		})
+	}
+}
+
+func TestAdoptIPs(t *testing.T) {
+	tests := []struct {
+		name       string
+		ipAdoption bool
+		// ippool of orphaned claim
+		ippool  string
+		adopted bool
+	}{
+		{name: "ipAdoption", ipAdoption: true, ippool: "pool", adopted: true},
+		{name: "no ipAdoption", ippool: "pool"},
+		{name: "ipAdoption with another ippool", ipAdoption: true, ippool: "other"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// created again with the same name after the old one is deleted
+			als := newTestAlcorSet(1)
+			als.UID = "als-uid-new"
+			als.Spec.IPAdoption = test.ipAdoption
+			claim := newTestIPClaim(als, 0, testIP(0))
+			claim.OwnerReferences = nil
+			claim.Labels[AlcorSetOrphanLabel] = "true"
+			claim.Spec.IPPool = test.ippool
+			r := newTestReconciler(t, als, claim)
+			backend, err := r.getBackend(als)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := r.adoptIPs(als); err != nil {
+				t.Fatalf("Failed to adopt IPs, since: %v", err)
+			}
+			claim = getTestIPClaim(t, r, getPodName(als, 0))
+			if adopted := metav1.IsControlledBy(claim, als) && !isOrphaned(claim); adopted != test.adopted {
+				t.Errorf("expected claim adopted %v, got %v", test.adopted, claim)
+			}
+			claimed, err := backend.GetClaimedIP(als, 0)
+			if err != nil {
+				t.Fatalf("Failed to get claimed IP, since: %v", err)
+			}
+			if reused := claimed != nil && claimed.ip == testIP(0); reused != test.adopted {
+				t.Errorf("expected IP reused %v, got %v", test.adopted, claimed)
+			}
+
+			// orphaned claims are kept when scaled down, for later adoption
+			if _, err := backend.ReleaseIP(als, 0); err != nil {
+				t.Fatalf("Failed to release IP, since: %v", err)
+			}
+			if kept := getTestIPClaim(t, r, getPodName(als, 0)) != nil; kept == test.adopted {
+				t.Errorf("expected claim kept %v, got %v", !test.adopted, kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package alcorset
+
+import (
+	"context"
+	"fmt"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	ipclaim "github.com/onionpiece/ipclaim/pkg/apis/alcor/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ipClaimBackend uses IPs in spec.ips, or claims IPs by IPClaim from spec.ippool.
+// It leaves pod annotations to backends embedding it.
+type ipClaimBackend struct {
+	client client.Client
+}
+
+func (b *ipClaimBackend) Finalizer() string {
+	return FinalizerIPClaim
+}
+
+func (b *ipClaimBackend) ClaimIP(als *alcorv1alpha1.AlcorSet, podIdx int) error {
+	if len(als.Spec.IPs) > 0 {
+		// fixed IP, no IPClaim needed
+		return nil
+	}
+	podName := getPodName(als, podIdx)
+	ipClaimRef := &ipclaim.IPClaim{}
+	err := b.client.Get(context.TODO(), types.NamespacedName{Name: podName, Namespace: als.Namespace}, ipClaimRef)
+	if err == nil && isOrphaned(ipClaimRef) {
+		return fmt.Errorf("ipclaim %s is orphaned and not adopted, set ipAdoption with the same ippool to adopt it, or delete it", podName)
+	}
+	if err == nil || !errors.IsNotFound(err) {
+		// existing claim is not recreated, even if it's being deleted, until it's gone
+		return err
+	}
+	alcorSetLogger(als).Info("Creating a new IPClaim", "ordinal", podIdx, "claim", podName, "ippool", als.Spec.IPPool)
+	newIPClaim := newIPClaimForCR(als, podName)
+	if err := b.client.Create(context.TODO(), newIPClaim); err != nil {
+		return fmt.Errorf("Fail to create ipclaim, since: %v", err)
+	}
+	return nil
+}
+
+func (b *ipClaimBackend) GetClaimedIP(als *alcorv1alpha1.AlcorSet, podIdx int) (*claimedIP, error) {
+	if len(als.Spec.IPs) > 0 {
+		// pod with index N uses IPs[N] directly
+		if podIdx >= len(als.Spec.IPs) {
+			return nil, nil
+		}
+		return &claimedIP{ip: als.Spec.IPs[podIdx]}, nil
+	}
+	podName := getPodName(als, podIdx)
+	ipClaimRef := &ipclaim.IPClaim{}
+	if err := b.client.Get(context.TODO(), types.NamespacedName{Name: podName, Namespace: als.Namespace}, ipClaimRef); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if ipClaimRef.DeletionTimestamp != nil || isOrphaned(ipClaimRef) || ipClaimRef.Status.IP == "" {
+		return nil, nil
+	}
+	return &claimedIP{ip: ipClaimRef.Status.IP, claimName: ipClaimRef.Name, createdAt: ipClaimRef.CreationTimestamp}, nil
+}
+
+func (b *ipClaimBackend) Release(als *alcorv1alpha1.AlcorSet) ([]string, error) {
+	ipclaims := &ipclaim.IPClaimList{}
+	opts := []client.ListOption{
+		client.InNamespace(als.Namespace),
+		client.MatchingLabels{AlcorSetAppLabel: als.Name},
+	}
+	if err := b.client.List(context.TODO(), ipclaims, opts...); err != nil {
+		if errors.IsNotFound(err) {
+			alcorSetLogger(als).Info("No ipclaims found, consider the resources are deleted")
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Failed to release ipclaims, found error when list ipclaims: %v", err)
+	}
+	releasedIPs := []string{}
+	for _, ipclaim := range ipclaims.Items {
+		if isOrphaned(&ipclaim) {
+			// left by AlcorSet deleted before
+			continue
+		}
+		if err := b.client.Delete(context.TODO(), &ipclaim); err != nil {
+			return releasedIPs, fmt.Errorf("Failed to release ipclaims, found error when delete ipclaim: %v", err)
+		}
+		releasedIPs = append(releasedIPs, ipclaim.Status.IP)
+	}
+	return releasedIPs, nil
+}
+
+func (b *ipClaimBackend) ListClaimedIPs(als *alcorv1alpha1.AlcorSet) (map[int]*claimedIP, error) {
+	claimed := map[int]*claimedIP{}
+	if len(als.Spec.IPs) > 0 {
+		return claimed, nil
+	}
+	ipclaims := &ipclaim.IPClaimList{}
+	opts := []client.ListOption{
+		client.InNamespace(als.Namespace),
+		client.MatchingLabels{AlcorSetAppLabel: als.Name},
+	}
+	if err := b.client.List(context.TODO(), ipclaims, opts...); err != nil {
+		return nil, fmt.Errorf("Failed to list ipclaims, since: %v", err)
+	}
+	for _, ipClaimRef := range ipclaims.Items {
+		idx := getIndexByName(ipClaimRef.Name)
+		if ipClaimRef.Name != getPodName(als, idx) || ipClaimRef.DeletionTimestamp != nil || isOrphaned(&ipClaimRef) {
+			continue
+		}
+		claimed[idx] = &claimedIP{ip: ipClaimRef.Status.IP, claimName: ipClaimRef.Name, createdAt: ipClaimRef.CreationTimestamp}
+	}
+	return claimed, nil
+}
+
+func (b *ipClaimBackend) ReleaseIP(als *alcorv1alpha1.AlcorSet, podIdx int) (string, error) {
+	if len(als.Spec.IPs) > 0 {
+		return "", nil
+	}
+	ipClaimRef := &ipclaim.IPClaim{}
+	if err := b.client.Get(context.TODO(), types.NamespacedName{Name: getPodName(als, podIdx), Namespace: als.Namespace}, ipClaimRef); err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if isOrphaned(ipClaimRef) {
+		// left by AlcorSet deleted before, kept for adoption
+		return "", nil
+	}
+	if err := b.client.Delete(context.TODO(), ipClaimRef); err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("Failed to release ipclaim %s, since: %v", ipClaimRef.Name, err)
+	}
+	return ipClaimRef.Status.IP, nil
+}
+
+func (b *ipClaimBackend) Orphan(als *alcorv1alpha1.AlcorSet) ([]string, error) {
+	if len(als.Spec.IPs) > 0 {
+		return nil, nil
+	}
+	ipclaims := &ipclaim.IPClaimList{}
+	opts := []client.ListOption{
+		client.InNamespace(als.Namespace),
+		client.MatchingLabels{AlcorSetAppLabel: als.Name},
+	}
+	if err := b.client.List(context.TODO(), ipclaims, opts...); err != nil {
+		return nil, fmt.Errorf("Failed to list ipclaims, since: %v", err)
+	}
+	orphanedIPs := []string{}
+	for i := range ipclaims.Items {
+		ipClaimRef := &ipclaims.Items[i]
+		if !orphanObject(ipClaimRef, als) {
+			continue
+		}
+		if err := b.client.Update(context.TODO(), ipClaimRef); err != nil {
+			return orphanedIPs, fmt.Errorf("Failed to orphan ipclaim %s, since: %v", ipClaimRef.Name, err)
+		}
+		orphanedIPs = append(orphanedIPs, ipClaimRef.Status.IP)
+	}
+	return orphanedIPs, nil
+}
+
+func (b *ipClaimBackend) Adopt(als *alcorv1alpha1.AlcorSet) ([]string, error) {
+	if len(als.Spec.IPs) > 0 {
+		return nil, nil
+	}
+	ipclaims := &ipclaim.IPClaimList{}
+	opts := []client.ListOption{
+		client.InNamespace(als.Namespace),
+		client.MatchingLabels{AlcorSetAppLabel: als.Name, AlcorSetOrphanLabel: "true"},
+	}
+	if err := b.client.List(context.TODO(), ipclaims, opts...); err != nil {
+		return nil, fmt.Errorf("Failed to list ipclaims, since: %v", err)
+	}
+	adoptedIPs := []string{}
+	for i := range ipclaims.Items {
+		ipClaimRef := &ipclaims.Items[i]
+		// IP from another ippool cannot be used
+		if ipClaimRef.Spec.IPPool != als.Spec.IPPool || !adoptObject(ipClaimRef, als) {
+			continue
+		}
+		if err := b.client.Update(context.TODO(), ipClaimRef); err != nil {
+			return adoptedIPs, fmt.Errorf("Failed to adopt ipclaim %s, since: %v", ipClaimRef.Name, err)
+		}
+		adoptedIPs = append(adoptedIPs, ipClaimRef.Status.IP)
+	}
+	return adoptedIPs, nil
+}
@@ -0,0 +1,83 @@
+package alcorset
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// phases of AlcorSet for reconcile metrics
+	phaseRaising = "raising"
+	phaseFalling = "falling"
+	phaseSteady  = "steady"
+
+	// results of reconcile for reconcile metrics
+	resultSuccess = "success"
+	resultRequeue = "requeue"
+	resultError   = "error"
+)
+
+var (
+	ipClaimDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "alcorset_ip_claim_duration_seconds",
+		Help:    "Time from IPClaim or VPCIPClaim created to IP assigned",
+		Buckets: []float64{0.5, 1, 2, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"backend"})
+
+	pendingIPClaims = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "alcorset_pending_ip_claims",
+		Help: "Number of pods of AlcorSet waiting for IP claimed, count series above 0 for sets stuck on IP claims",
+	}, []string{"namespace", "alcorset"})
+
+	podsByReadiness = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "alcorset_pods",
+		Help: "Number of pods of AlcorSet which are not terminating, by readiness",
+	}, []string{"namespace", "alcorset", "ready"})
+
+	ipReleaseFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alcorset_ip_release_failures_total",
+		Help: "Number of failures releasing IPClaims or VPCIPClaims",
+	}, []string{"backend"})
+
+	finalizerRemovalDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "alcorset_finalizer_removal_duration_seconds",
+		Help:    "Time from AlcorSet marked as deleted to its finalizer removed",
+		Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800},
+	}, []string{"backend"})
+
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alcorset_reconcile_total",
+		Help: "Number of reconciles of AlcorSet, by phase and result",
+	}, []string{"phase", "result"})
+
+	leakedClaims = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "alcorset_leaked_ip_claims",
+		Help: "Number of IPClaims or VPCIPClaims found leaked in last sweep, by kind and reason",
+	}, []string{"kind", "reason"})
+
+	leakedClaimsReleased = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alcorset_leaked_ip_claims_released_total",
+		Help: "Number of leaked IPClaims or VPCIPClaims released after grace period",
+	}, []string{"kind"})
+)
+
+func init() {
+	// served with controller-runtime metrics on metrics port
+	metrics.Registry.MustRegister(
+		ipClaimDuration,
+		pendingIPClaims,
+		podsByReadiness,
+		ipReleaseFailures,
+		finalizerRemovalDuration,
+		reconcileTotal,
+		leakedClaims,
+		leakedClaimsReleased,
+	)
+}
+
+// deleteAlcorSetMetrics removes metrics of AlcorSet which is gone
+func deleteAlcorSetMetrics(namespace, name string) {
+	pendingIPClaims.DeleteLabelValues(namespace, name)
+	podsByReadiness.DeleteLabelValues(namespace, name, "true")
+	podsByReadiness.DeleteLabelValues(namespace, name, "false")
+}
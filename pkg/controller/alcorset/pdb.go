@@ -0,0 +1,90 @@
+package alcorset
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// syncDisruptionBudget creates, updates or deletes PodDisruptionBudget named after AlcorSet,
+// according to spec.disruptionBudget
+func (r *ReconcileAlcorSet) syncDisruptionBudget(als *alcorv1alpha1.AlcorSet) error {
+	found := &policyv1beta1.PodDisruptionBudget{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: als.Name, Namespace: als.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	if als.Spec.DisruptionBudget == nil {
+		if exists && metav1.IsControlledBy(found, als) {
+			alcorSetLogger(als).Info("Deleting PodDisruptionBudget")
+			if err := r.client.Delete(context.TODO(), found); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("Failed to delete PodDisruptionBudget, since: %v", err)
+			}
+		}
+		return nil
+	}
+
+	pdb := newPodDisruptionBudgetForCR(als)
+	if err := controllerutil.SetControllerReference(als, pdb, r.scheme); err != nil {
+		return err
+	}
+	if !exists {
+		alcorSetLogger(als).Info("Creating PodDisruptionBudget")
+		if err := r.client.Create(context.TODO(), pdb); err != nil {
+			return fmt.Errorf("Failed to create PodDisruptionBudget, since: %v", err)
+		}
+		return nil
+	}
+	if !metav1.IsControlledBy(found, als) {
+		return fmt.Errorf("PodDisruptionBudget %s.%s exists but is not owned by AlcorSet", found.Namespace, found.Name)
+	}
+	if reflect.DeepEqual(found.Spec, pdb.Spec) {
+		return nil
+	}
+	alcorSetLogger(als).Info("Updating PodDisruptionBudget")
+	found.Spec = pdb.Spec
+	if err := r.client.Update(context.TODO(), found); err != nil {
+		return fmt.Errorf("Failed to update PodDisruptionBudget, since: %v", err)
+	}
+	return nil
+}
+
+func newPodDisruptionBudgetForCR(als *alcorv1alpha1.AlcorSet) *policyv1beta1.PodDisruptionBudget {
+	budget := als.Spec.DisruptionBudget
+	spec := policyv1beta1.PodDisruptionBudgetSpec{
+		Selector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{AlcorSetAppLabel: als.Name},
+		},
+	}
+	if budget.MinAvailable != nil {
+		minAvailable := *budget.MinAvailable
+		// otherwise no pod can be evicted
+		if minAvailable.Type == intstr.Int && minAvailable.IntValue() > als.Spec.Replicas {
+			minAvailable = intstr.FromInt(als.Spec.Replicas)
+		}
+		spec.MinAvailable = &minAvailable
+	} else if budget.MaxUnavailable != nil {
+		maxUnavailable := *budget.MaxUnavailable
+		spec.MaxUnavailable = &maxUnavailable
+	}
+	return &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      als.Name,
+			Namespace: als.Namespace,
+			Labels: map[string]string{
+				AlcorSetAppLabel: als.Name,
+			},
+		},
+		Spec: spec,
+	}
+}
@@ -0,0 +1,144 @@
+package alcorset
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getPVCName returns name of PVC created from claim template for pod, like volumeClaimTemplates in StatefulSet
+func getPVCName(claimName, podName string) string {
+	return claimName + PodNameIndexSep + podName
+}
+
+// getPVCRetentionPolicy returns policy for AlcorSet deleted and scaled down, default is Retain
+func getPVCRetentionPolicy(als *alcorv1alpha1.AlcorSet) (whenDeleted, whenScaled alcorv1alpha1.PersistentVolumeClaimRetentionPolicyType) {
+	whenDeleted = alcorv1alpha1.RetainPersistentVolumeClaimRetentionPolicyType
+	whenScaled = alcorv1alpha1.RetainPersistentVolumeClaimRetentionPolicyType
+	if policy := als.Spec.PersistentVolumeClaimRetentionPolicy; policy != nil {
+		if policy.WhenDeleted != "" {
+			whenDeleted = policy.WhenDeleted
+		}
+		if policy.WhenScaled != "" {
+			whenScaled = policy.WhenScaled
+		}
+	}
+	return whenDeleted, whenScaled
+}
+
+// newPVCForCR returns PVC for pod with given index from claim template.
+// PVCs are not owned by AlcorSet, so they will not be garbage collected with AlcorSet,
+// and data will be kept for pod recreated with the same name.
+func newPVCForCR(als *alcorv1alpha1.AlcorSet, template *corev1.PersistentVolumeClaim, podIdx int) *corev1.PersistentVolumeClaim {
+	pvc := template.DeepCopy()
+	pvc.ObjectMeta = metav1.ObjectMeta{
+		Name:        getPVCName(template.Name, getPodName(als, podIdx)),
+		Namespace:   als.Namespace,
+		Labels:      pvc.Labels,
+		Annotations: pvc.Annotations,
+	}
+	if pvc.Labels == nil {
+		pvc.Labels = map[string]string{}
+	}
+	pvc.Labels[AlcorSetAppLabel] = als.Name
+	pvc.Status = corev1.PersistentVolumeClaimStatus{}
+	return pvc
+}
+
+// ensurePVCs creates PVCs from volumeClaimTemplates for pod with given index if they don't exist
+func (r *ReconcileAlcorSet) ensurePVCs(als *alcorv1alpha1.AlcorSet, podIdx int) error {
+	for i := range als.Spec.VolumeClaimTemplates {
+		pvc := newPVCForCR(als, &als.Spec.VolumeClaimTemplates[i], podIdx)
+		found := &corev1.PersistentVolumeClaim{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: pvc.Name, Namespace: pvc.Namespace}, found)
+		if err == nil {
+			if found.DeletionTimestamp != nil {
+				return fmt.Errorf("PVC %s.%s is being deleted, wait it gone", found.Namespace, found.Name)
+			}
+			continue
+		} else if !errors.IsNotFound(err) {
+			return err
+		}
+		alcorSetLogger(als).Info("Creating PVC", "ordinal", podIdx, "pvc", pvc.Name)
+		if err := r.client.Create(context.TODO(), pvc); err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("Failed to create PVC %s, since: %v", pvc.Name, err)
+		}
+	}
+	return nil
+}
+
+// getPVCs returns PVCs created by AlcorSet
+func (r *ReconcileAlcorSet) getPVCs(als *alcorv1alpha1.AlcorSet) (*corev1.PersistentVolumeClaimList, error) {
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	opts := []client.ListOption{
+		client.InNamespace(als.Namespace),
+		client.MatchingLabels{AlcorSetAppLabel: als.Name},
+	}
+	if err := r.client.List(context.TODO(), pvcs, opts...); err != nil {
+		return pvcs, err
+	}
+	return pvcs, nil
+}
+
+// deletePVCs deletes PVCs created by AlcorSet for pods with index no less than border,
+// PVCs used by existing pods are skipped
+func (r *ReconcileAlcorSet) deletePVCs(als *alcorv1alpha1.AlcorSet, pods *corev1.PodList, border int) error {
+	pvcs, err := r.getPVCs(als)
+	if err != nil {
+		return err
+	}
+	podMap := getPodMap(pods)
+	for _, pvc := range pvcs.Items {
+		if pvc.DeletionTimestamp != nil {
+			continue
+		}
+		for _, template := range als.Spec.VolumeClaimTemplates {
+			podName := strings.TrimPrefix(pvc.Name, template.Name+PodNameIndexSep)
+			idx := getIndexByName(podName)
+			if podName != getPodName(als, idx) || idx < border {
+				continue
+			}
+			if _, ok := podMap[podName]; ok {
+				continue
+			}
+			alcorSetLogger(als).Info("Deleting PVC", "ordinal", idx, "pvc", pvc.Name)
+			if err := r.client.Delete(context.TODO(), &pvc); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("Failed to delete PVC %s, since: %v", pvc.Name, err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// addPVCVolumes adds or replaces volumes in pod spec with PVCs created from volumeClaimTemplates
+func addPVCVolumes(als *alcorv1alpha1.AlcorSet, podSpec *corev1.PodSpec, podName string) {
+	for _, template := range als.Spec.VolumeClaimTemplates {
+		volume := corev1.Volume{
+			Name: template.Name,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: getPVCName(template.Name, podName),
+				},
+			},
+		}
+		replaced := false
+		for i := range podSpec.Volumes {
+			if podSpec.Volumes[i].Name == template.Name {
+				podSpec.Volumes[i] = volume
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			podSpec.Volumes = append(podSpec.Volumes, volume)
+		}
+	}
+}
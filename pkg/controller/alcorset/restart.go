@@ -0,0 +1,112 @@
+package alcorset
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// restartBaseBackoff is backoff before recreating a pod for the first time, and doubled for each restart
+	restartBaseBackoff = 10 * time.Second
+	// restartMaxBackoff is max backoff before recreating a pod
+	restartMaxBackoff = 5 * time.Minute
+)
+
+// restartTerminatedPods deletes failed or succeeded pods, so they will be recreated with the same name,
+// hostname and IP claim. Backoff doubles with restarts of the index, pod will be deleted at once if it
+// has been running longer than the backoff since last restart.
+// Returns duration to check pods again, 0 means no need.
+func (r *ReconcileAlcorSet) restartTerminatedPods(als *alcorv1alpha1.AlcorSet) (time.Duration, error) {
+	pods, err := r.listPods(als)
+	if err != nil {
+		return 0, err
+	}
+
+	var requeueAfter time.Duration
+	alsStatus := als.Status.DeepCopy()
+	deleted := []string{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		idx := getIndexByName(pod.Name)
+		if pod.DeletionTimestamp != nil || idx >= als.Spec.Replicas {
+			continue
+		}
+		if pod.Status.Phase != corev1.PodFailed && pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		member := getMember(alsStatus, als, idx)
+		if member.LastRestartTime != nil {
+			wait := getRestartBackoff(member.Restarts) - time.Since(member.LastRestartTime.Time)
+			if wait > 0 {
+				if requeueAfter == 0 || wait < requeueAfter {
+					requeueAfter = wait
+				}
+				continue
+			}
+		}
+		reason := getTerminationReason(pod)
+		alcorSetLogger(als).Info("Pod is terminated, going to recreate it", "ordinal", idx, "pod", pod.Name, "reason", reason)
+		if err := r.client.Delete(context.TODO(), pod); err != nil && !errors.IsNotFound(err) {
+			return 0, err
+		}
+		now := metav1.Now()
+		member.Restarts++
+		member.LastTerminationReason = reason
+		member.LastRestartTime = &now
+		r.recordPodEvent(als, pod, corev1.EventTypeWarning, ReasonPodRestarted,
+			"Pod %s is %s, recreating it, restarts: %d", pod.Name, reason, member.Restarts)
+		deleted = append(deleted, pod.Name)
+	}
+	if len(deleted) == 0 {
+		return requeueAfter, nil
+	}
+	// restarts are written by syncStatus at the end of reconcile
+	als.Status = *alsStatus
+	return requeueAfter, nil
+}
+
+// getMember returns member with given index in status, a new one will be added if not found
+func getMember(alsStatus *alcorv1alpha1.AlcorSetStatus, als *alcorv1alpha1.AlcorSet, idx int) *alcorv1alpha1.AlcorSetMember {
+	for i := range alsStatus.Members {
+		if alsStatus.Members[i].Ordinal == idx {
+			return &alsStatus.Members[i]
+		}
+	}
+	alsStatus.Members = append(alsStatus.Members, alcorv1alpha1.AlcorSetMember{
+		Ordinal:  idx,
+		PodName:  getPodName(als, idx),
+		Hostname: getPodHostname(als, idx),
+	})
+	return &alsStatus.Members[len(alsStatus.Members)-1]
+}
+
+// getRestartBackoff returns backoff before recreating pod which has been restarted given times
+func getRestartBackoff(restarts int32) time.Duration {
+	backoff := restartBaseBackoff
+	for i := int32(1); i < restarts && backoff < restartMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > restartMaxBackoff {
+		return restartMaxBackoff
+	}
+	return backoff
+}
+
+// getTerminationReason returns phase of pod with reason, from pod status or the first terminated container
+func getTerminationReason(pod *corev1.Pod) string {
+	if pod.Status.Reason != "" {
+		return fmt.Sprintf("%s(%s)", pod.Status.Phase, pod.Status.Reason)
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if t := cs.State.Terminated; t != nil && (t.ExitCode != 0 || pod.Status.Phase == corev1.PodSucceeded) {
+			return fmt.Sprintf("%s(container %s %s, exit code %d)", pod.Status.Phase, cs.Name, t.Reason, t.ExitCode)
+		}
+	}
+	return string(pod.Status.Phase)
+}
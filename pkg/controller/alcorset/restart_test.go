@@ -0,0 +1,98 @@
+package alcorset
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRestartTerminatedPods(t *testing.T) {
+	tests := []struct {
+		name  string
+		idx   int
+		phase corev1.PodPhase
+		// restarts of index, and how long ago it was restarted last time
+		restarts    int32
+		restartedAt time.Duration
+		recreated   bool
+		// expected requeue after, 0 means no requeue
+		requeueAfter time.Duration
+	}{
+		{name: "running pod", phase: corev1.PodRunning},
+		{name: "failed pod restarted first time", phase: corev1.PodFailed, recreated: true},
+		{name: "succeeded pod restarted first time", phase: corev1.PodSucceeded, recreated: true},
+		{name: "failed pod in backoff", phase: corev1.PodFailed, restarts: 1, restartedAt: 4 * time.Second,
+			requeueAfter: 6 * time.Second},
+		{name: "failed pod after backoff", phase: corev1.PodFailed, restarts: 1, restartedAt: 11 * time.Second, recreated: true},
+		{name: "failed pod in doubled backoff", phase: corev1.PodFailed, restarts: 3, restartedAt: 30 * time.Second,
+			requeueAfter: 10 * time.Second},
+		{name: "failed pod after doubled backoff", phase: corev1.PodFailed, restarts: 3, restartedAt: 41 * time.Second, recreated: true},
+		{name: "failed pod beyond replicas", idx: 2, phase: corev1.PodFailed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(2)
+			if test.restarts > 0 {
+				lastRestart := metav1.NewTime(time.Now().Add(-test.restartedAt))
+				als.Status.Members = []alcorv1alpha1.AlcorSetMember{
+					{Ordinal: test.idx, PodName: getPodName(als, test.idx), Restarts: test.restarts, LastRestartTime: &lastRestart},
+				}
+			}
+			pod := newTestPod(als, test.idx, testIP(test.idx), false)
+			pod.Status.Phase = test.phase
+			r := newTestReconciler(t, als, pod)
+
+			requeueAfter, err := r.restartTerminatedPods(als)
+			if err != nil {
+				t.Fatalf("Failed to restart pods, since: %v", err)
+			}
+			// allow time passed in test
+			if requeueAfter > test.requeueAfter || requeueAfter < test.requeueAfter-time.Second {
+				t.Errorf("expected requeue after %v, got %v", test.requeueAfter, requeueAfter)
+			}
+			if recreated := len(listTestPods(t, r, als).Items) == 0; recreated != test.recreated {
+				t.Fatalf("expected pod recreated %v, got %v", test.recreated, recreated)
+			}
+			if !test.recreated {
+				if member := getMember(&als.Status, als, test.idx); member.Restarts != test.restarts {
+					t.Errorf("expected restarts %d not changed, got %d", test.restarts, member.Restarts)
+				}
+				return
+			}
+			member := getMember(&als.Status, als, test.idx)
+			if member.Restarts != test.restarts+1 {
+				t.Errorf("expected restarts %d, got %d", test.restarts+1, member.Restarts)
+			}
+			if !strings.HasPrefix(member.LastTerminationReason, string(test.phase)) {
+				t.Errorf("expected termination reason of %s, got %s", test.phase, member.LastTerminationReason)
+			}
+			if member.LastRestartTime == nil || time.Since(member.LastRestartTime.Time) > time.Second {
+				t.Errorf("expected last restart time updated, got %v", member.LastRestartTime)
+			}
+		})
+	}
+}
+
+func TestGetRestartBackoff(t *testing.T) {
+	tests := []struct {
+		restarts int32
+		expected time.Duration
+	}{
+		{restarts: 0, expected: 10 * time.Second},
+		{restarts: 1, expected: 10 * time.Second},
+		{restarts: 2, expected: 20 * time.Second},
+		{restarts: 3, expected: 40 * time.Second},
+		{restarts: 5, expected: 160 * time.Second},
+		{restarts: 6, expected: 5 * time.Minute},
+		{restarts: 100, expected: 5 * time.Minute},
+	}
+	for _, test := range tests {
+		if backoff := getRestartBackoff(test.restarts); backoff != test.expected {
+			t.Errorf("expected backoff %v for %d restarts, got %v", test.expected, test.restarts, backoff)
+		}
+	}
+}
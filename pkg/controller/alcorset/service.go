@@ -0,0 +1,76 @@
+package alcorset
+
+import (
+	"context"
+	"fmt"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// syncHeadlessService creates headless Service named spec.serviceName, and deletes Services
+// created for previous serviceName
+func (r *ReconcileAlcorSet) syncHeadlessService(als *alcorv1alpha1.AlcorSet) error {
+	services := &corev1.ServiceList{}
+	opts := []client.ListOption{
+		client.InNamespace(als.Namespace),
+		client.MatchingLabels{AlcorSetAppLabel: als.Name},
+	}
+	if err := r.client.List(context.TODO(), services, opts...); err != nil {
+		return err
+	}
+	for _, svc := range services.Items {
+		if svc.Name != als.Spec.ServiceName && metav1.IsControlledBy(&svc, als) {
+			alcorSetLogger(als).Info("Deleting headless Service", "service", svc.Name)
+			if err := r.client.Delete(context.TODO(), &svc); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("Failed to delete headless Service, since: %v", err)
+			}
+		}
+	}
+	if als.Spec.ServiceName == "" {
+		return nil
+	}
+
+	found := &corev1.Service{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: als.Spec.ServiceName, Namespace: als.Namespace}, found)
+	if err == nil {
+		if !metav1.IsControlledBy(found, als) {
+			return fmt.Errorf("Service %s.%s exists but is not owned by AlcorSet", found.Namespace, found.Name)
+		}
+		return nil
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+	svc := newHeadlessServiceForCR(als)
+	if err := controllerutil.SetControllerReference(als, svc, r.scheme); err != nil {
+		return err
+	}
+	alcorSetLogger(als).Info("Creating headless Service", "service", svc.Name)
+	if err := r.client.Create(context.TODO(), svc); err != nil {
+		return fmt.Errorf("Failed to create headless Service, since: %v", err)
+	}
+	return nil
+}
+
+func newHeadlessServiceForCR(als *alcorv1alpha1.AlcorSet) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      als.Spec.ServiceName,
+			Namespace: als.Namespace,
+			Labels: map[string]string{
+				AlcorSetAppLabel: als.Name,
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Selector: map[string]string{
+				AlcorSetAppLabel: als.Name,
+			},
+		},
+	}
+}
@@ -0,0 +1,130 @@
+package alcorset
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	ipclaim "github.com/onionpiece/ipclaim/pkg/apis/alcor/v1alpha1"
+	saishang "github.com/onionpiece/saishang/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// IPPoolKind is kind of IPPool which IPClaim claims IP from
+	IPPoolKind = "IPPool"
+	// IPClaimKind is kind of IPClaim
+	IPClaimKind = "IPClaim"
+
+	// keys of SR-IOV network fields in IPClaim status and IPPool spec
+	sriovVlanKey    = "vlan"
+	sriovGatewayKey = "gateway"
+	sriovMaskKey    = "mask"
+)
+
+// sriovBackend setups claimed or fixed IP on SR-IOV VF via saishang annotations
+type sriovBackend struct {
+	ipClaimBackend
+}
+
+func newSriovBackend(c client.Client) networkBackend {
+	return &sriovBackend{ipClaimBackend{client: c}}
+}
+
+// sriovNetwork is network for SR-IOV pods, which saishang needs to setup VF
+type sriovNetwork struct {
+	vlan    string
+	gateway string
+	mask    string
+}
+
+func (n *sriovNetwork) complete() bool {
+	return n.vlan != "" && n.gateway != "" && n.mask != ""
+}
+
+// fillFrom fills empty fields from obj, fields are under given path
+func (n *sriovNetwork) fillFrom(obj map[string]interface{}, path ...string) {
+	get := func(key string) string {
+		v, found, err := unstructured.NestedFieldNoCopy(obj, append(path, key)...)
+		if err != nil || !found || v == nil {
+			return ""
+		}
+		// vlan may be a number
+		return fmt.Sprint(v)
+	}
+	if n.vlan == "" {
+		n.vlan = get(sriovVlanKey)
+	}
+	if n.gateway == "" {
+		n.gateway = get(sriovGatewayKey)
+	}
+	if n.mask == "" {
+		n.mask = get(sriovMaskKey)
+	}
+}
+
+// getSriovNetwork gets vlan, gateway and mask from status of IPClaim named claimName at first,
+// and then from spec of IPPool in AlcorSet spec. IPClaim and IPPool are read as unstructured,
+// since network fields are optional for them.
+func (b *sriovBackend) getSriovNetwork(als *alcorv1alpha1.AlcorSet, claimName string) (*sriovNetwork, error) {
+	network := &sriovNetwork{}
+	if claimName != "" {
+		claim := &unstructured.Unstructured{}
+		claim.SetGroupVersionKind(ipclaim.SchemeGroupVersion.WithKind(IPClaimKind))
+		err := b.client.Get(context.TODO(), types.NamespacedName{Name: claimName, Namespace: als.Namespace}, claim)
+		if err != nil && !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return nil, fmt.Errorf("Failed to get ipclaim %s, since: %v", claimName, err)
+		} else if err == nil {
+			network.fillFrom(claim.Object, "status")
+		}
+	}
+	if network.complete() || als.Spec.IPPool == "" {
+		return network, nil
+	}
+	pool := &unstructured.Unstructured{}
+	pool.SetGroupVersionKind(ipclaim.SchemeGroupVersion.WithKind(IPPoolKind))
+	if err := b.client.Get(context.TODO(), types.NamespacedName{Name: als.Spec.IPPool, Namespace: als.Namespace}, pool); err != nil {
+		if meta.IsNoMatchError(err) {
+			// IPPool is not served in this cluster, nothing more to look up
+			alcorSetLogger(als).Info("No IPPool kind found, SR-IOV network may be incomplete", "kind", IPPoolKind)
+			return network, nil
+		}
+		return nil, fmt.Errorf("Failed to get ippool %s, since: %v", als.Spec.IPPool, err)
+	}
+	network.fillFrom(pool.Object, "spec")
+	return network, nil
+}
+
+// PodAnnotations returns annotations for saishang to setup SR-IOV VF for pod, pod cannot be created
+// if any of vlan, gateway and mask is not found, since saishang cannot setup VF without them.
+func (b *sriovBackend) PodAnnotations(als *alcorv1alpha1.AlcorSet, claimed *claimedIP) (map[string]string, error) {
+	network, err := b.getSriovNetwork(als, claimed.claimName)
+	if err != nil {
+		return nil, err
+	}
+	if !network.complete() {
+		return nil, fmt.Errorf("SR-IOV network for IP %s is incomplete, vlan: %q, gateway: %q, mask: %q, "+
+			"they should be in status of ipclaim or spec of ippool", claimed.ip, network.vlan, network.gateway, network.mask)
+	}
+	mbps := ""
+	if als.Spec.Mbps > 0 {
+		mbps = strconv.Itoa(als.Spec.Mbps)
+	}
+	return map[string]string{
+		saishang.AnnoKeySriovIP:    claimed.ip,
+		saishang.AnnoKeySriovVlan:  network.vlan,
+		saishang.AnnoKeySriovRoute: network.gateway,
+		saishang.AnnoKeySriovMask:  network.mask,
+		saishang.AnnoKeySriovMbps:  mbps,
+	}, nil
+}
+
+func (b *sriovBackend) PodIP(pod *corev1.Pod) string {
+	return pod.Annotations[saishang.AnnoKeySriovIP]
+}
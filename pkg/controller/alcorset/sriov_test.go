@@ -0,0 +1,122 @@
+package alcorset
+
+import (
+	"reflect"
+	"testing"
+
+	ipclaim "github.com/onionpiece/ipclaim/pkg/apis/alcor/v1alpha1"
+	saishang "github.com/onionpiece/saishang/pkg/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// newTestNetworkObject returns IPClaim or IPPool with SR-IOV network fields under given field
+func newTestNetworkObject(kind, name, field string, network map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{field: network}}
+	obj.SetGroupVersionKind(ipclaim.SchemeGroupVersion.WithKind(kind))
+	obj.SetName(name)
+	obj.SetNamespace(testNamespace)
+	return obj
+}
+
+func TestSriovPodAnnotations(t *testing.T) {
+	tests := []struct {
+		name string
+		// network in status of IPClaim and spec of IPPool, nil means object not found
+		claimNetwork map[string]interface{}
+		poolNetwork  map[string]interface{}
+		fixedIP      bool
+		mbps         int
+		// expected vlan, route and mask, nil means pod is refused
+		expected []string
+	}{
+		{name: "network from ipclaim",
+			claimNetwork: map[string]interface{}{"vlan": int64(100), "gateway": "10.0.0.254", "mask": "24"},
+			expected:     []string{"100", "10.0.0.254", "24"}},
+		{name: "network from ipclaim and ippool",
+			claimNetwork: map[string]interface{}{"vlan": "200"},
+			poolNetwork:  map[string]interface{}{"vlan": "100", "gateway": "10.0.0.254", "mask": "24"},
+			expected:     []string{"200", "10.0.0.254", "24"}},
+		{name: "network from ippool",
+			claimNetwork: map[string]interface{}{},
+			poolNetwork:  map[string]interface{}{"vlan": "100", "gateway": "10.0.0.254", "mask": "24"},
+			expected:     []string{"100", "10.0.0.254", "24"}},
+		{name: "network of fixed IP from ippool", fixedIP: true,
+			poolNetwork: map[string]interface{}{"vlan": "100", "gateway": "10.0.0.254", "mask": "24"},
+			expected:    []string{"100", "10.0.0.254", "24"}},
+		{name: "with mbps", mbps: 100,
+			claimNetwork: map[string]interface{}{"vlan": "100", "gateway": "10.0.0.254", "mask": "24"},
+			expected:     []string{"100", "10.0.0.254", "24"}},
+		{name: "mask not found",
+			claimNetwork: map[string]interface{}{"vlan": "100"},
+			poolNetwork:  map[string]interface{}{"gateway": "10.0.0.254"}},
+		{name: "ippool not found",
+			claimNetwork: map[string]interface{}{"vlan": "100"}},
+		{name: "network of fixed IP not found", fixedIP: true,
+			poolNetwork: map[string]interface{}{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(1)
+			als.Spec.NetworkBackend = SriovBackend
+			als.Spec.Mbps = test.mbps
+			claimed := &claimedIP{ip: "10.0.0.1", claimName: getPodName(als, 0)}
+			if test.fixedIP {
+				als.Spec.IPs = []string{"10.0.0.1"}
+				claimed.claimName = ""
+			}
+			objs := []runtime.Object{als}
+			if test.claimNetwork != nil {
+				objs = append(objs, newTestNetworkObject(IPClaimKind, getPodName(als, 0), "status", test.claimNetwork))
+			}
+			if test.poolNetwork != nil {
+				objs = append(objs, newTestNetworkObject(IPPoolKind, als.Spec.IPPool, "spec", test.poolNetwork))
+			}
+			r := newTestReconciler(t, objs...)
+			backend, err := r.getBackend(als)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			annotations, err := backend.PodAnnotations(als, claimed)
+			if test.expected == nil {
+				if err == nil {
+					t.Errorf("expected pod refused with incomplete network, got annotations %v", annotations)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to get pod annotations, since: %v", err)
+			}
+			mbps := ""
+			if test.mbps > 0 {
+				mbps = "100"
+			}
+			expected := map[string]string{
+				saishang.AnnoKeySriovIP:    "10.0.0.1",
+				saishang.AnnoKeySriovVlan:  test.expected[0],
+				saishang.AnnoKeySriovRoute: test.expected[1],
+				saishang.AnnoKeySriovMask:  test.expected[2],
+				saishang.AnnoKeySriovMbps:  mbps,
+			}
+			if !reflect.DeepEqual(annotations, expected) {
+				t.Errorf("expected annotations %v, got %v", expected, annotations)
+			}
+		})
+	}
+}
+
+// pod is not created with incomplete SR-IOV network, and creating is retried
+func TestCreateSriovPodWithIncompleteNetwork(t *testing.T) {
+	als := newTestAlcorSet(1)
+	als.Spec.NetworkBackend = SriovBackend
+	pool := newTestNetworkObject(IPPoolKind, als.Spec.IPPool, "spec", map[string]interface{}{"vlan": "100"})
+	r := newTestReconciler(t, als, newTestIPClaim(als, 0, "10.0.0.1"), pool)
+
+	if _, err := r.createPod(als, listTestPods(t, r, als), []int{0}); err == nil {
+		t.Errorf("expected error for incomplete SR-IOV network")
+	}
+	if pods := listTestPods(t, r, als); len(pods.Items) != 0 {
+		t.Errorf("expected no pod created, got %d", len(pods.Items))
+	}
+}
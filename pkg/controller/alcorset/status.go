@@ -0,0 +1,346 @@
+package alcorset
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
+)
+
+const (
+	// ReasonAllIPsClaimed is condition reason for IPs of all replicas are claimed
+	ReasonAllIPsClaimed = "AllIPsClaimed"
+	// ReasonWaitIPClaimReady is condition reason for IPClaim or VPCIPClaim not having IP yet
+	ReasonWaitIPClaimReady = "WaitIPClaimReady"
+	// ReasonFailedToClaimIP is condition reason for failing to create IPClaim or VPCIPClaim
+	ReasonFailedToClaimIP = "FailedToClaimIP"
+	// ReasonInvalidFixedIPs is condition reason for spec.ips cannot be used
+	ReasonInvalidFixedIPs = "InvalidFixedIPs"
+	// ReasonAllPodsReady is condition reason for all replicas are ready
+	ReasonAllPodsReady = "AllPodsReady"
+	// ReasonPodsNotReady is condition reason for some replicas are not ready
+	ReasonPodsNotReady = "PodsNotReady"
+	// ReasonScalingUp is condition reason for creating pods
+	ReasonScalingUp = "ScalingUp"
+	// ReasonScalingDown is condition reason for deleting pods
+	ReasonScalingDown = "ScalingDown"
+	// ReasonRollingUpdate is condition reason for recreating out of date pods
+	ReasonRollingUpdate = "RollingUpdate"
+	// ReasonStable is condition reason for nothing in progress
+	ReasonStable = "Stable"
+	// ReasonReconcileError is condition reason for reconcile failure
+	ReasonReconcileError = "ReconcileError"
+	// ReasonPodCrashLooping is condition reason for containers in CrashLoopBackOff
+	ReasonPodCrashLooping = "PodCrashLooping"
+	// ReasonAsExpected is condition reason for AlcorSet not degraded
+	ReasonAsExpected = "AsExpected"
+
+	crashLoopBackOff = "CrashLoopBackOff"
+)
+
+// ipClaimError means IPClaim or VPCIPClaim cannot be created for pod
+type ipClaimError struct {
+	podName string
+	err     error
+}
+
+func (e *ipClaimError) Error() string {
+	return fmt.Sprintf("Failed to claim IP for pod %s, since: %v", e.podName, e.err)
+}
+
+// syncStatus computes status from pods and IP claims as a snapshot, and updates status if it differs from
+// stored status, which is the status fetched before reconcile. It's the only place status is written when
+// AlcorSet is not being deleted, other steps of reconcile only record what can't be observed, e.g. restarts
+// and teardowns, in als.Status.
+func (r *ReconcileAlcorSet) syncStatus(als *alcorv1alpha1.AlcorSet, stored *alcorv1alpha1.AlcorSetStatus, reconcileErr error) error {
+	pods, err := r.listPods(als)
+	if err != nil {
+		return err
+	}
+
+	alsStatus := als.Status.DeepCopy()
+	alsStatus.ObservedGeneration = als.Generation
+	alsStatus.Selector = labels.SelectorFromSet(labels.Set{AlcorSetAppLabel: als.Name}).String()
+	alsStatus.ReadyReplicas = 0
+	alsStatus.UpdatedReplicas = 0
+	existing, terminating := 0, 0
+	notReady, crashLooping := []string{}, []string{}
+	for _, pod := range pods.Items {
+		idx := getIndexByName(pod.Name)
+		if pod.DeletionTimestamp != nil {
+			terminating++
+			continue
+		}
+		if idx >= als.Spec.Replicas {
+			continue
+		}
+		existing++
+		if pod.Labels[AlcorSetSpecLabel] == getTemplateHash(als, getPodTemplate(als, idx)) {
+			alsStatus.UpdatedReplicas++
+		}
+		if pod.Status.Phase == corev1.PodRunning && podutil.IsPodReady(&pod) {
+			alsStatus.ReadyReplicas++
+		} else {
+			notReady = append(notReady, pod.Name)
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Waiting != nil && cs.State.Waiting.Reason == crashLoopBackOff {
+				crashLooping = append(crashLooping, pod.Name)
+				break
+			}
+		}
+	}
+
+	alsStatus.Replicas = len(pods.Items) - terminating
+	alsStatus.Count = existing
+
+	// members
+	backend, err := r.getBackend(als)
+	if err != nil {
+		return err
+	}
+	fixedIPsStatus := checkFixedIPs(als)
+	podMap := getPodMap(pods)
+	alsStatus.Members = nil
+	alsStatus.PodIPs = nil
+	claimed := 0
+	for idx := 0; idx != als.Spec.Replicas; idx++ {
+		member := alcorv1alpha1.AlcorSetMember{
+			Ordinal:  idx,
+			PodName:  getPodName(als, idx),
+			Hostname: getPodHostname(als, idx),
+		}
+		// restarts are recorded when pods are recreated, keep them
+		for _, old := range als.Status.Members {
+			if old.Ordinal == idx {
+				member.Restarts = old.Restarts
+				member.LastTerminationReason = old.LastTerminationReason
+				member.LastRestartTime = old.LastRestartTime
+				break
+			}
+		}
+		if fixedIPsStatus == "" {
+			claimed, err := backend.GetClaimedIP(als, idx)
+			if err != nil {
+				return err
+			}
+			if claimed != nil {
+				member.IP = claimed.ip
+				member.ClaimName = claimed.claimName
+				member.NICID = claimed.nicID
+				member.NICMAC = claimed.nicMAC
+			}
+		}
+		if pod, ok := podMap[member.PodName]; ok && pod.DeletionTimestamp == nil {
+			// report IP pod is really using, spec.ips may be changed after pod is created
+			if ip := backend.PodIP(&pod); ip != "" {
+				member.IP = ip
+			}
+			member.NodeName = pod.Spec.NodeName
+			member.Ready = pod.Status.Phase == corev1.PodRunning && podutil.IsPodReady(&pod)
+		}
+		if pod, ok := podMap[member.PodName]; ok && isPodBound(als, &pod) {
+			ip := backend.PodIP(&pod)
+			if ip == "" {
+				ip = member.IP
+			}
+			if alsStatus.PodIPs == nil {
+				alsStatus.PodIPs = map[string]string{}
+			}
+			alsStatus.PodIPs[member.PodName] = ip
+		}
+		if member.IP != "" {
+			claimed++
+		}
+		alsStatus.Members = append(alsStatus.Members, member)
+	}
+
+	// claimed IPs ordered by index, and retained IPs for indexes beyond replicas and without pod
+	alsStatus.ClaimedIPs = []string{}
+	alsStatus.RetainedIPs = nil
+	if fixedIPsStatus == "" {
+		claims, err := backend.ListClaimedIPs(als)
+		if err != nil {
+			return err
+		}
+		idxes := []int{}
+		for idx, claim := range claims {
+			if claim.ip != "" {
+				idxes = append(idxes, idx)
+			}
+		}
+		sort.Ints(idxes)
+		retain := getIPRetentionPolicy(als) == alcorv1alpha1.RetainIPRetentionPolicy
+		for _, idx := range idxes {
+			alsStatus.ClaimedIPs = append(alsStatus.ClaimedIPs, claims[idx].ip)
+			if _, ok := podMap[getPodName(als, idx)]; !ok && retain && idx >= als.Spec.Replicas {
+				alsStatus.RetainedIPs = append(alsStatus.RetainedIPs, claims[idx].ip)
+			}
+		}
+	}
+
+	// IPsClaimed
+	claimErr, isClaimErr := reconcileErr.(*ipClaimError)
+	if fixedIPsStatus != "" {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetIPsClaimed, corev1.ConditionFalse, ReasonInvalidFixedIPs, fixedIPsStatus)
+	} else if isClaimErr {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetIPsClaimed, corev1.ConditionFalse, ReasonFailedToClaimIP, claimErr.Error())
+	} else if claimed < als.Spec.Replicas {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetIPsClaimed, corev1.ConditionFalse, ReasonWaitIPClaimReady,
+			fmt.Sprintf("%d of %d IPs claimed", claimed, als.Spec.Replicas))
+	} else {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetIPsClaimed, corev1.ConditionTrue, ReasonAllIPsClaimed, "")
+	}
+
+	// PodsReady
+	if alsStatus.ReadyReplicas == als.Spec.Replicas {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetPodsReady, corev1.ConditionTrue, ReasonAllPodsReady, "")
+	} else {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetPodsReady, corev1.ConditionFalse, ReasonPodsNotReady,
+			fmt.Sprintf("%d of %d pods ready, not ready pods: %s", alsStatus.ReadyReplicas, als.Spec.Replicas, strings.Join(notReady, ",")))
+	}
+
+	// Progressing
+	if existing < als.Spec.Replicas {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetProgressing, corev1.ConditionTrue, ReasonScalingUp,
+			fmt.Sprintf("%d of %d pods created", existing, als.Spec.Replicas))
+	} else if len(pods.Items)-terminating > existing && scaleDownBlocked(als, pods) {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetProgressing, corev1.ConditionTrue, ReasonScaleDownBlocked,
+			fmt.Sprintf("%d pods to delete after all pods running and ready", len(pods.Items)-terminating-existing))
+	} else if len(pods.Items)-terminating > existing {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetProgressing, corev1.ConditionTrue, ReasonScalingDown,
+			fmt.Sprintf("%d pods to delete", len(pods.Items)-terminating-existing))
+	} else if alsStatus.UpdatedReplicas < als.Spec.Replicas || terminating > 0 {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetProgressing, corev1.ConditionTrue, ReasonRollingUpdate,
+			fmt.Sprintf("%d of %d pods updated", alsStatus.UpdatedReplicas, als.Spec.Replicas))
+	} else {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetProgressing, corev1.ConditionFalse, ReasonStable, "")
+	}
+
+	// Degraded
+	if reconcileErr != nil && !isClaimErr {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetDegraded, corev1.ConditionTrue, ReasonReconcileError, reconcileErr.Error())
+	} else if len(crashLooping) > 0 {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetDegraded, corev1.ConditionTrue, ReasonPodCrashLooping,
+			fmt.Sprintf("pods in %s: %s", crashLoopBackOff, strings.Join(crashLooping, ",")))
+	} else {
+		setCondition(alsStatus, alcorv1alpha1.AlcorSetDegraded, corev1.ConditionFalse, ReasonAsExpected, "")
+	}
+
+	alsStatus.Status = summarizeConditions(alsStatus, fixedIPsStatus)
+
+	pending := 0
+	if fixedIPsStatus == "" {
+		pending = als.Spec.Replicas - claimed
+	}
+	pendingIPClaims.WithLabelValues(als.Namespace, als.Name).Set(float64(pending))
+	podsByReadiness.WithLabelValues(als.Namespace, als.Name, "true").Set(float64(alsStatus.ReadyReplicas))
+	podsByReadiness.WithLabelValues(als.Namespace, als.Name, "false").Set(float64(alsStatus.Replicas - alsStatus.ReadyReplicas))
+
+	als.Status = *alsStatus
+	return r.updateStatus(als, stored)
+}
+
+// updateStatus writes status of AlcorSet only if it differs from stored status
+func (r *ReconcileAlcorSet) updateStatus(als *alcorv1alpha1.AlcorSet, stored *alcorv1alpha1.AlcorSetStatus) error {
+	if reflect.DeepEqual(*stored, als.Status) {
+		return nil
+	}
+	return r.client.Status().Update(context.TODO(), als)
+}
+
+// isPodBound returns whether pod keeps its IP until it's gone, terminating ones included. Pods AlcorSet deletes
+// itself, which are out of date or failed or succeeded, are not bound, so they are not reported missing when
+// recreated. Pods evicted or on nodes which are gone are still bound.
+func isPodBound(als *alcorv1alpha1.AlcorSet, pod *corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+		return false
+	}
+	return pod.Labels[AlcorSetSpecLabel] == getTemplateHash(als, getPodTemplate(als, getIndexByName(pod.Name)))
+}
+
+// scaleDownBlocked returns whether pods beyond replicas cannot be deleted in sequence case, since some pods
+// are not running and ready. ReasonScaleDownBlocked of events is also reason of Progressing condition then.
+func scaleDownBlocked(als *alcorv1alpha1.AlcorSet, pods *corev1.PodList) bool {
+	if !inSequence(als) || !hasPodsBeyondReplicas(als, pods) {
+		return false
+	}
+	blocked := false
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			// pods are being deleted
+			return false
+		}
+		if pod.Status.Phase != corev1.PodRunning || !podutil.IsPodReady(&pod) {
+			blocked = true
+		}
+	}
+	return blocked
+}
+
+// summarizeConditions returns a short status from conditions, for kubectl to print
+func summarizeConditions(alsStatus *alcorv1alpha1.AlcorSetStatus, fixedIPsStatus string) string {
+	if fixedIPsStatus != "" {
+		return fixedIPsStatus
+	}
+	if cond := getCondition(alsStatus, alcorv1alpha1.AlcorSetDegraded); cond != nil && cond.Status == corev1.ConditionTrue {
+		return StatusDegraded
+	}
+	if cond := getCondition(alsStatus, alcorv1alpha1.AlcorSetIPsClaimed); cond != nil && cond.Status != corev1.ConditionTrue {
+		if cond.Reason == ReasonFailedToClaimIP {
+			return StatusFailedToClaimIP
+		}
+		return StatusWaitIPClaimReady
+	}
+	if cond := getCondition(alsStatus, alcorv1alpha1.AlcorSetProgressing); cond != nil && cond.Status == corev1.ConditionTrue {
+		return StatusProgressing
+	}
+	if cond := getCondition(alsStatus, alcorv1alpha1.AlcorSetPodsReady); cond != nil && cond.Status != corev1.ConditionTrue {
+		return StatusPodsNotReady
+	}
+	return StatusReady
+}
+
+// getPhase returns phase of AlcorSet from Progressing condition, for reconcile metrics
+func getPhase(alsStatus *alcorv1alpha1.AlcorSetStatus) string {
+	cond := getCondition(alsStatus, alcorv1alpha1.AlcorSetProgressing)
+	if cond == nil || cond.Status != corev1.ConditionTrue {
+		return phaseSteady
+	}
+	if cond.Reason == ReasonScalingDown || cond.Reason == ReasonScaleDownBlocked {
+		return phaseFalling
+	}
+	return phaseRaising
+}
+
+func getCondition(alsStatus *alcorv1alpha1.AlcorSetStatus, condType alcorv1alpha1.AlcorSetConditionType) *alcorv1alpha1.AlcorSetCondition {
+	for i := range alsStatus.Conditions {
+		if alsStatus.Conditions[i].Type == condType {
+			return &alsStatus.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// setCondition updates condition with given type, lastTransitionTime only changes when status changes
+func setCondition(alsStatus *alcorv1alpha1.AlcorSetStatus, condType alcorv1alpha1.AlcorSetConditionType,
+	status corev1.ConditionStatus, reason, message string) {
+	cond := getCondition(alsStatus, condType)
+	if cond == nil {
+		alsStatus.Conditions = append(alsStatus.Conditions, alcorv1alpha1.AlcorSetCondition{Type: condType})
+		cond = &alsStatus.Conditions[len(alsStatus.Conditions)-1]
+	}
+	if cond.Status != status {
+		cond.Status = status
+		cond.LastTransitionTime = metav1.Now()
+	}
+	cond.Reason = reason
+	cond.Message = message
+}
@@ -0,0 +1,126 @@
+package alcorset
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSyncStatusMemberIPs(t *testing.T) {
+	als := newTestAlcorSet(3)
+	als.Spec.IPs = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	pod0 := newTestPod(als, 0, "10.0.0.1", true)
+	pod1 := newTestPod(als, 1, "10.0.0.2", true)
+	// spec.ips changed after pods are created, pod 2 is not created yet
+	als.Spec.IPs = []string{"10.0.0.1", "10.0.0.9", "10.0.0.8"}
+	r := newTestReconciler(t, als, pod0, pod1)
+
+	if err := r.syncStatus(als, als.Status.DeepCopy(), nil); err != nil {
+		t.Fatalf("Failed to sync status, since: %v", err)
+	}
+	expected := []string{"10.0.0.1", "10.0.0.2", "10.0.0.8"}
+	if len(als.Status.Members) != len(expected) {
+		t.Fatalf("expected %d members, got %v", len(expected), als.Status.Members)
+	}
+	for idx, member := range als.Status.Members {
+		if member.IP != expected[idx] {
+			t.Errorf("expected IP %s for member %d, got %s", expected[idx], idx, member.IP)
+		}
+	}
+}
+
+// pods with no IP annotation fall back to IPs from spec
+func TestSyncStatusMemberIPsWithoutAnnotation(t *testing.T) {
+	als := newTestAlcorSet(1)
+	als.Spec.IPs = []string{"10.0.0.1"}
+	pod := newTestPod(als, 0, "10.0.0.1", true)
+	pod.Annotations = map[string]string{}
+	r := newTestReconciler(t, als, pod)
+
+	if err := r.syncStatus(als, als.Status.DeepCopy(), nil); err != nil {
+		t.Fatalf("Failed to sync status, since: %v", err)
+	}
+	if len(als.Status.Members) != 1 || als.Status.Members[0].IP != "10.0.0.1" {
+		t.Errorf("expected member using IP from spec, got %v", als.Status.Members)
+	}
+	if !als.Status.Members[0].Ready {
+		t.Errorf("expected member ready, got %v", als.Status.Members[0])
+	}
+}
+
+func TestSyncStatusRecomputed(t *testing.T) {
+	now := metav1.Now()
+	tests := []struct {
+		name string
+		idx  int
+		pod  func(pod *corev1.Pod)
+		// expected count, and whether pod is bound to its IP in status
+		count int
+		bound bool
+	}{
+		{name: "ready pod", count: 1, bound: true},
+		{name: "pending pod", pod: func(pod *corev1.Pod) { pod.Status.Phase = corev1.PodPending }, count: 1, bound: true},
+		{name: "terminating pod", pod: func(pod *corev1.Pod) { pod.DeletionTimestamp = &now }, bound: true},
+		{name: "failed pod", pod: func(pod *corev1.Pod) { pod.Status.Phase = corev1.PodFailed }, count: 1},
+		{name: "out of date pod", pod: func(pod *corev1.Pod) { pod.Labels[AlcorSetSpecLabel] = "outdated" }, count: 1},
+		{name: "pod beyond replicas", idx: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(1)
+			// stale status, e.g. claim of 10.0.0.9 deleted manually and pod 5 gone after scaling down
+			als.Status.Count = 5
+			als.Status.ClaimedIPs = []string{"10.0.0.9"}
+			als.Status.PodIPs = map[string]string{getPodName(als, 5): "10.0.0.6"}
+			pod := newTestPod(als, test.idx, testIP(test.idx), true)
+			if test.pod != nil {
+				test.pod(pod)
+			}
+			r := newTestReconciler(t, als, pod, newTestIPClaim(als, test.idx, testIP(test.idx)))
+
+			if err := r.syncStatus(als, als.Status.DeepCopy(), nil); err != nil {
+				t.Fatalf("Failed to sync status, since: %v", err)
+			}
+			if als.Status.Count != test.count {
+				t.Errorf("expected count %d, got %d", test.count, als.Status.Count)
+			}
+			if expected := []string{testIP(test.idx)}; !reflect.DeepEqual(als.Status.ClaimedIPs, expected) {
+				t.Errorf("expected claimed IPs %v, got %v", expected, als.Status.ClaimedIPs)
+			}
+			var expected map[string]string
+			if test.bound {
+				expected = map[string]string{pod.Name: testIP(test.idx)}
+			}
+			if !reflect.DeepEqual(als.Status.PodIPs, expected) {
+				t.Errorf("expected pod IPs %v, got %v", expected, als.Status.PodIPs)
+			}
+		})
+	}
+}
+
+// status is written only if it's changed
+func TestSyncStatusUpdatedOnlyIfChanged(t *testing.T) {
+	als := newTestAlcorSet(1)
+	r := newTestReconciler(t, als, newTestPod(als, 0, testIP(0), true), newTestIPClaim(als, 0, testIP(0)))
+	key := types.NamespacedName{Name: als.Name, Namespace: als.Namespace}
+
+	resourceVersions := []string{}
+	for i := 0; i != 2; i++ {
+		if err := r.syncStatus(als, als.Status.DeepCopy(), nil); err != nil {
+			t.Fatalf("Failed to sync status, since: %v", err)
+		}
+		found := &alcorv1alpha1.AlcorSet{}
+		if err := r.client.Get(context.TODO(), key, found); err != nil {
+			t.Fatal(err)
+		}
+		resourceVersions = append(resourceVersions, found.ResourceVersion)
+	}
+	if resourceVersions[0] != resourceVersions[1] {
+		t.Errorf("expected status not written again without changes, got resource versions %v", resourceVersions)
+	}
+}
@@ -0,0 +1,234 @@
+package alcorset
+
+import (
+	"context"
+	"time"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	ipclaim "github.com/onionpiece/ipclaim/pkg/apis/alcor/v1alpha1"
+	vpcipclaim "github.com/onionpiece/vpcipclaim/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// LeakedClaimSweepInterval is interval to look for leaked IPClaims and VPCIPClaims
+var LeakedClaimSweepInterval = 5 * time.Minute
+
+// LeakedClaimGracePeriod is how long a claim of AlcorSet which is gone keeps leaked before it's released, 0 means
+// only reporting it. It counts from when the claim is found leaked with its pod gone, as quarantine of the IP.
+var LeakedClaimGracePeriod time.Duration
+
+const (
+	// reasons why claim is leaked, for events and metrics
+	leakOwnerGone  = "OwnerGone"
+	leakOutOfRange = "OutOfRange"
+
+	ipClaimKind    = "IPClaim"
+	vpcIPClaimKind = "VPCIPClaim"
+)
+
+// sweptClaim is IPClaim or VPCIPClaim created by AlcorSet
+type sweptClaim struct {
+	obj  runtime.Object
+	meta metav1.Object
+	kind string
+	ip   string
+}
+
+// claimSweeper periodically finds IPClaims and VPCIPClaims created by AlcorSet but not used any more, which are
+// claims of AlcorSet deleted without finalizer, or claims out of range not torn down after scaling down. Claims
+// with ordinal in range are never leaked, since pods may be created for them later. Leaked claims are reported
+// by events and metrics. Claims out of range are torn down by reconcile of their AlcorSet with quarantine, and
+// claims of AlcorSet which is gone are released after LeakedClaimGracePeriod if it's set.
+type claimSweeper struct {
+	client   client.Client
+	recorder record.EventRecorder
+	// when claims are found leaked, keyed by claim UID
+	leakedSince map[types.UID]time.Time
+	// AlcorSets to reconcile, for claims out of range to be torn down
+	events chan event.GenericEvent
+}
+
+// blank assignment to verify that claimSweeper implements manager.Runnable
+var _ manager.Runnable = &claimSweeper{}
+
+func newClaimSweeper(mgr manager.Manager) *claimSweeper {
+	return &claimSweeper{
+		client:      mgr.GetClient(),
+		recorder:    mgr.GetEventRecorderFor("alcorset-claim-sweeper"),
+		leakedSince: map[types.UID]time.Time{},
+		events:      make(chan event.GenericEvent, 1024),
+	}
+}
+
+// Start sweeps leaked claims until stop is closed
+func (s *claimSweeper) Start(stop <-chan struct{}) error {
+	wait.Until(s.sweep, LeakedClaimSweepInterval, stop)
+	return nil
+}
+
+func (s *claimSweeper) sweep() {
+	claims, err := s.listClaims()
+	if err != nil {
+		log.Error(err, "Failed to list claims to sweep")
+		return
+	}
+	leakedClaims.Reset()
+	stillLeaked := map[types.UID]time.Time{}
+	for _, claim := range claims {
+		reason, als, err := s.getLeakReason(claim)
+		if err != nil {
+			log.Error(err, "Failed to check claim", "namespace", claim.meta.GetNamespace(), "claim", claim.meta.GetName())
+			continue
+		}
+		if reason == "" {
+			continue
+		}
+		since, ok := s.leakedSince[claim.meta.GetUID()]
+		if !ok {
+			since = time.Now()
+			log.Info("Found leaked claim", "namespace", claim.meta.GetNamespace(), "kind", claim.kind,
+				"claim", claim.meta.GetName(), "ip", claim.ip, "reason", reason)
+			s.recorder.Eventf(claim.obj, corev1.EventTypeWarning, ReasonLeakedClaim, "%s with IP %s is leaked: %s", claim.kind, claim.ip, reason)
+			if als != nil {
+				s.recorder.Eventf(als, corev1.EventTypeWarning, ReasonLeakedClaim, "%s %s with IP %s is leaked: %s",
+					claim.kind, claim.meta.GetName(), claim.ip, reason)
+			}
+		}
+		leakedClaims.WithLabelValues(claim.kind, reason).Inc()
+		if reason == leakOutOfRange {
+			s.enqueue(als)
+		} else if LeakedClaimGracePeriod > 0 && time.Since(since) >= LeakedClaimGracePeriod {
+			if released, err := s.releaseClaim(claim); err != nil {
+				log.Error(err, "Failed to release leaked claim", "namespace", claim.meta.GetNamespace(), "claim", claim.meta.GetName())
+			} else if released {
+				s.recorder.Eventf(claim.obj, corev1.EventTypeNormal, ReasonLeakedClaimReleased, "Leaked %s with IP %s is released", claim.kind, claim.ip)
+				leakedClaimsReleased.WithLabelValues(claim.kind).Inc()
+				continue
+			}
+		}
+		stillLeaked[claim.meta.GetUID()] = since
+	}
+	s.leakedSince = stillLeaked
+}
+
+// enqueue requests reconcile of AlcorSet, it's skipped if too many are waiting, and retried in next sweep
+func (s *claimSweeper) enqueue(als *alcorv1alpha1.AlcorSet) {
+	select {
+	case s.events <- event.GenericEvent{Meta: als, Object: als}:
+	default:
+	}
+}
+
+// listClaims lists IPClaims and VPCIPClaims labeled by AlcorSet, orphaned ones are left to be adopted
+func (s *claimSweeper) listClaims() ([]sweptClaim, error) {
+	claims := []sweptClaim{}
+	ipclaims := &ipclaim.IPClaimList{}
+	if err := s.client.List(context.TODO(), ipclaims); err != nil && !meta.IsNoMatchError(err) {
+		return nil, err
+	}
+	for i := range ipclaims.Items {
+		claim := &ipclaims.Items[i]
+		claims = append(claims, sweptClaim{obj: claim, meta: claim, kind: ipClaimKind, ip: claim.Status.IP})
+	}
+	vpcipclaims := &vpcipclaim.VPCIPClaimList{}
+	if err := s.client.List(context.TODO(), vpcipclaims); err != nil && !meta.IsNoMatchError(err) {
+		return nil, err
+	}
+	for i := range vpcipclaims.Items {
+		claim := &vpcipclaims.Items[i]
+		claims = append(claims, sweptClaim{obj: claim, meta: claim, kind: vpcIPClaimKind, ip: claim.Status.IP})
+	}
+
+	swept := []sweptClaim{}
+	for _, claim := range claims {
+		labels := claim.meta.GetLabels()
+		if labels[AlcorSetAppLabel] == "" || labels[AlcorSetOrphanLabel] == "true" || claim.meta.GetDeletionTimestamp() != nil {
+			continue
+		}
+		swept = append(swept, claim)
+	}
+	return swept, nil
+}
+
+// getLeakReason returns why claim is leaked, empty if it's used or may be used by AlcorSet, and AlcorSet if it exists
+func (s *claimSweeper) getLeakReason(claim sweptClaim) (string, *alcorv1alpha1.AlcorSet, error) {
+	// claim is named after its pod, and IP is still in use while pod exists
+	podExists, err := s.podExists(claim.meta.GetNamespace(), claim.meta.GetName())
+	if err != nil || podExists {
+		return "", nil, err
+	}
+	als := &alcorv1alpha1.AlcorSet{}
+	key := types.NamespacedName{Name: claim.meta.GetLabels()[AlcorSetAppLabel], Namespace: claim.meta.GetNamespace()}
+	if err := s.client.Get(context.TODO(), key, als); err != nil {
+		if errors.IsNotFound(err) {
+			return leakOwnerGone, nil, nil
+		}
+		return "", nil, err
+	}
+	if owner := metav1.GetControllerOf(claim.meta); owner == nil || owner.UID != als.UID {
+		return leakOwnerGone, als, nil
+	}
+	if als.DeletionTimestamp != nil {
+		// claims are being released
+		return "", als, nil
+	}
+	idx := getIndexByName(claim.meta.GetName())
+	if claim.meta.GetName() != getPodName(als, idx) || idx < als.Spec.Replicas {
+		// claims not named after pods are released when AlcorSet is deleted, and claims in range may be in-flight,
+		// e.g. claimed but pod not created yet
+		return "", als, nil
+	}
+	if getIPRetentionPolicy(als) != alcorv1alpha1.ReleaseIPRetentionPolicy {
+		// retained for scaling up again
+		return "", als, nil
+	}
+	if getTeardown(&als.Status, idx).Phase == alcorv1alpha1.TeardownPodGone {
+		// in quarantine, released by tearDownIPs later
+		return "", als, nil
+	}
+	return leakOutOfRange, als, nil
+}
+
+func (s *claimSweeper) podExists(namespace, name string) (bool, error) {
+	pod := &corev1.Pod{}
+	if err := s.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, pod); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// releaseClaim releases leaked claim of AlcorSet which is gone by network backend, the same way as releasing
+// IP of pod torn down, returns whether it's released. Claims not named after pods are only reported.
+func (s *claimSweeper) releaseClaim(claim sweptClaim) (bool, error) {
+	als := &alcorv1alpha1.AlcorSet{ObjectMeta: metav1.ObjectMeta{
+		Name:      claim.meta.GetLabels()[AlcorSetAppLabel],
+		Namespace: claim.meta.GetNamespace(),
+	}}
+	idx := getIndexByName(claim.meta.GetName())
+	if claim.meta.GetName() != getPodName(als, idx) {
+		return false, nil
+	}
+	factory := backendFactories[CalicoBackend]
+	if claim.kind == vpcIPClaimKind {
+		factory = backendFactories[VPCBackend]
+	}
+	log.Info("Releasing leaked claim", "namespace", claim.meta.GetNamespace(), "kind", claim.kind,
+		"claim", claim.meta.GetName(), "ip", claim.ip)
+	if _, err := factory(s.client).ReleaseIP(als, idx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
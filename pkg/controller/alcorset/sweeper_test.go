@@ -0,0 +1,122 @@
+package alcorset
+
+import (
+	"testing"
+	"time"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestSweepLeakedClaims(t *testing.T) {
+	tests := []struct {
+		name string
+		// AlcorSet scaled down from 3 to 2, nil means it's gone
+		mutate func(als *alcorv1alpha1.AlcorSet)
+		gone   bool
+		// index of claim with IP 10.0.0.x, and whether its pod exists
+		idx       int
+		podExists bool
+		orphaned  bool
+		// whether claim was found leaked before, longer than grace period
+		leakedLongAgo bool
+		// expected reason, whether AlcorSet is enqueued to tear down claim, and whether claim is released
+		reason   string
+		enqueued bool
+		released bool
+	}{
+		{name: "claim in use", idx: 1, podExists: true},
+		{name: "in-flight claim, pod not created yet", idx: 1},
+		{name: "in-flight claim, found long ago", idx: 1, leakedLongAgo: true},
+		{name: "out of range, retained", idx: 2, leakedLongAgo: true},
+		{name: "out of range, pod not deleted yet", idx: 2, podExists: true,
+			mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.IPRetentionPolicy = alcorv1alpha1.ReleaseIPRetentionPolicy }},
+		{name: "out of range, in quarantine", idx: 2, mutate: func(als *alcorv1alpha1.AlcorSet) {
+			als.Spec.IPRetentionPolicy = alcorv1alpha1.ReleaseIPRetentionPolicy
+			als.Status.Teardowns = []alcorv1alpha1.AlcorSetTeardown{
+				{Ordinal: 2, IP: "10.0.0.3", Phase: alcorv1alpha1.TeardownPodGone, LastTransitionTime: metav1.Now()},
+			}
+		}},
+		{name: "out of range, not torn down", idx: 2, leakedLongAgo: true, reason: leakOutOfRange, enqueued: true,
+			mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.IPRetentionPolicy = alcorv1alpha1.ReleaseIPRetentionPolicy }},
+		{name: "out of range, failed to release", idx: 2, reason: leakOutOfRange, enqueued: true, mutate: func(als *alcorv1alpha1.AlcorSet) {
+			als.Spec.IPRetentionPolicy = alcorv1alpha1.ReleaseIPRetentionPolicy
+			als.Status.Teardowns = []alcorv1alpha1.AlcorSetTeardown{
+				{Ordinal: 2, IP: "10.0.0.3", Phase: alcorv1alpha1.TeardownNetworkTornDown, LastTransitionTime: metav1.Now()},
+			}
+		}},
+		{name: "AlcorSet deleting", idx: 2, leakedLongAgo: true, mutate: func(als *alcorv1alpha1.AlcorSet) {
+			now := metav1.Now()
+			als.DeletionTimestamp = &now
+		}},
+		{name: "owner gone, in grace period", idx: 1, gone: true, reason: leakOwnerGone},
+		{name: "owner gone, pod exists", idx: 1, gone: true, podExists: true},
+		{name: "owner gone, grace period passed", idx: 1, gone: true, leakedLongAgo: true, reason: leakOwnerGone, released: true},
+		{name: "owner recreated", idx: 1, leakedLongAgo: true, reason: leakOwnerGone, released: true,
+			mutate: func(als *alcorv1alpha1.AlcorSet) { als.UID = "als-uid-new" }},
+		{name: "orphaned", idx: 1, gone: true, orphaned: true, leakedLongAgo: true},
+	}
+	defer func(gracePeriod time.Duration) { LeakedClaimGracePeriod = gracePeriod }(LeakedClaimGracePeriod)
+	LeakedClaimGracePeriod = time.Minute
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(2)
+			claim := newTestIPClaim(als, test.idx, testIP(test.idx))
+			claim.UID = "claim-uid"
+			if test.orphaned {
+				orphanObject(claim, als)
+			}
+			if test.mutate != nil {
+				test.mutate(als)
+			}
+			objs := []runtime.Object{claim}
+			if !test.gone {
+				objs = append(objs, als)
+			}
+			if test.podExists {
+				objs = append(objs, newTestPod(als, test.idx, testIP(test.idx), true))
+			}
+			r := newTestReconciler(t, objs...)
+			s := &claimSweeper{
+				client:      r.client,
+				recorder:    r.recorder,
+				leakedSince: map[types.UID]time.Time{},
+				events:      make(chan event.GenericEvent, 1),
+			}
+			if test.leakedLongAgo {
+				s.leakedSince[claim.UID] = time.Now().Add(-2 * time.Minute)
+			}
+
+			claims, err := s.listClaims()
+			if err != nil {
+				t.Fatalf("Failed to list claims, since: %v", err)
+			}
+			if test.orphaned {
+				if len(claims) != 0 {
+					t.Errorf("expected orphaned claim left to be adopted, got %v", claims)
+				}
+			} else if len(claims) != 1 {
+				t.Fatalf("expected claim listed, got %v", claims)
+			} else if reason, _, err := s.getLeakReason(claims[0]); err != nil || reason != test.reason {
+				t.Errorf("expected leak reason %q, got %q, %v", test.reason, reason, err)
+			}
+
+			s.sweep()
+			if _, tracked := s.leakedSince[claim.UID]; tracked != (test.reason != "" && !test.released) {
+				t.Errorf("expected claim tracked as leaked %v, got %v", !tracked, tracked)
+			}
+			if reported := countEvents(r, ReasonLeakedClaim) > 0; reported != (test.reason != "" && !test.leakedLongAgo) {
+				t.Errorf("expected claim reported %v, got %v", !reported, reported)
+			}
+			if enqueued := len(s.events) == 1; enqueued != test.enqueued {
+				t.Errorf("expected AlcorSet enqueued %v, got %v", test.enqueued, enqueued)
+			}
+			if released := getTestIPClaim(t, r, claim.Name) == nil; released != test.released {
+				t.Errorf("expected claim released %v, got %v", test.released, released)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package alcorset
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// tearDownIPs moves teardown of indexes no less than border forward, from PodTerminating, PodGone,
+// NetworkTornDown to ClaimDeleted. IP claim of an index is only deleted after its pod is gone and
+// ipQuarantineSeconds has passed, so the IP will never be handed back while it may still be used by a pod.
+// Pods with indexes to tear down should have been deleted. Teardowns are recorded in als.Status but not
+// written. Returns whether all claims of those indexes are gone, and duration to check again for quarantine.
+func (r *ReconcileAlcorSet) tearDownIPs(als *alcorv1alpha1.AlcorSet, pods *corev1.PodList, backend networkBackend, border int) (bool, time.Duration, error) {
+	claims, err := backend.ListClaimedIPs(als)
+	if err != nil {
+		return false, 0, err
+	}
+	idxes := []int{}
+	for idx := range claims {
+		if idx >= border {
+			idxes = append(idxes, idx)
+		}
+	}
+	sort.Ints(idxes)
+
+	podMap := getPodMap(pods)
+	quarantine := time.Duration(als.Spec.IPQuarantineSeconds) * time.Second
+	var requeueAfter time.Duration
+	teardowns := []alcorv1alpha1.AlcorSetTeardown{}
+	releasedIPs := []string{}
+	for _, idx := range idxes {
+		teardown := getTeardown(&als.Status, idx)
+		if teardown.IP == "" {
+			teardown.IP = claims[idx].ip
+		}
+		if pod, ok := podMap[getPodName(als, idx)]; ok {
+			if pod.DeletionTimestamp != nil {
+				setTeardownPhase(&teardown, alcorv1alpha1.TeardownPodTerminating)
+				teardowns = append(teardowns, teardown)
+			}
+			continue
+		}
+		if teardown.Phase == "" || teardown.Phase == alcorv1alpha1.TeardownPodTerminating {
+			setTeardownPhase(&teardown, alcorv1alpha1.TeardownPodGone)
+		}
+		if teardown.Phase == alcorv1alpha1.TeardownPodGone {
+			if wait := quarantine - time.Since(teardown.LastTransitionTime.Time); wait > 0 {
+				if requeueAfter == 0 || wait < requeueAfter {
+					requeueAfter = wait
+				}
+			} else {
+				setTeardownPhase(&teardown, alcorv1alpha1.TeardownNetworkTornDown)
+			}
+		}
+		if teardown.Phase == alcorv1alpha1.TeardownNetworkTornDown {
+			alcorSetLogger(als).Info("Releasing IP of pod torn down", "ordinal", idx, "ip", teardown.IP)
+			ip, err := backend.ReleaseIP(als, idx)
+			if err != nil {
+				r.recorder.Eventf(als, corev1.EventTypeWarning, ReasonIPReleaseFailed, "Failed to release IP for index %d: %v", idx, err)
+				ipReleaseFailures.WithLabelValues(getNetworkBackend(als)).Inc()
+				return false, 0, err
+			}
+			if ip != "" {
+				releasedIPs = append(releasedIPs, ip)
+			}
+			setTeardownPhase(&teardown, alcorv1alpha1.TeardownClaimDeleted)
+		}
+		teardowns = append(teardowns, teardown)
+	}
+
+	if len(releasedIPs) > 0 {
+		r.recorder.Eventf(als, corev1.EventTypeNormal, ReasonIPsReleased, "Released IPs: %s", strings.Join(releasedIPs, ","))
+	}
+	if len(teardowns) == 0 {
+		teardowns = nil
+	}
+	als.Status.Teardowns = teardowns
+	return len(idxes) == 0, requeueAfter, nil
+}
+
+// getTeardown returns copy of teardown of given index in status, or a new one
+func getTeardown(alsStatus *alcorv1alpha1.AlcorSetStatus, idx int) alcorv1alpha1.AlcorSetTeardown {
+	for _, teardown := range alsStatus.Teardowns {
+		if teardown.Ordinal == idx {
+			return *teardown.DeepCopy()
+		}
+	}
+	return alcorv1alpha1.AlcorSetTeardown{Ordinal: idx}
+}
+
+func setTeardownPhase(teardown *alcorv1alpha1.AlcorSetTeardown, phase alcorv1alpha1.TeardownPhase) {
+	if teardown.Phase != phase {
+		teardown.Phase = phase
+		teardown.LastTransitionTime = metav1.Now()
+	}
+}
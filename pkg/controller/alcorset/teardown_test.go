@@ -0,0 +1,275 @@
+package alcorset
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestTearDownIPs(t *testing.T) {
+	now := metav1.Now()
+	longAgo := metav1.NewTime(now.Add(-2 * time.Minute))
+	tests := []struct {
+		name string
+		// pod of index 2, nil means pod is gone
+		pod      func(als *alcorv1alpha1.AlcorSet) *corev1.Pod
+		teardown *alcorv1alpha1.AlcorSetTeardown
+		// expected phase of teardown, and whether claim is kept
+		phase        alcorv1alpha1.TeardownPhase
+		claimKept    bool
+		requeueAfter bool
+	}{
+		{
+			name: "pod terminating",
+			pod: func(als *alcorv1alpha1.AlcorSet) *corev1.Pod {
+				pod := newTestPod(als, 2, "10.0.0.3", true)
+				pod.DeletionTimestamp = &now
+				return pod
+			},
+			phase:     alcorv1alpha1.TeardownPodTerminating,
+			claimKept: true,
+		},
+		{
+			name:         "pod gone, in quarantine",
+			phase:        alcorv1alpha1.TeardownPodGone,
+			claimKept:    true,
+			requeueAfter: true,
+		},
+		{
+			name: "pod terminating before, gone now, in quarantine",
+			teardown: &alcorv1alpha1.AlcorSetTeardown{
+				Ordinal: 2, IP: "10.0.0.3", Phase: alcorv1alpha1.TeardownPodTerminating, LastTransitionTime: longAgo,
+			},
+			phase:        alcorv1alpha1.TeardownPodGone,
+			claimKept:    true,
+			requeueAfter: true,
+		},
+		{
+			name: "quarantine passed",
+			teardown: &alcorv1alpha1.AlcorSetTeardown{
+				Ordinal: 2, IP: "10.0.0.3", Phase: alcorv1alpha1.TeardownPodGone, LastTransitionTime: longAgo,
+			},
+			phase: alcorv1alpha1.TeardownClaimDeleted,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(2)
+			als.Spec.IPRetentionPolicy = alcorv1alpha1.ReleaseIPRetentionPolicy
+			als.Spec.IPQuarantineSeconds = 60
+			if test.teardown != nil {
+				als.Status.Teardowns = []alcorv1alpha1.AlcorSetTeardown{*test.teardown}
+			}
+			objs := []runtime.Object{als, newTestIPClaim(als, 0, "10.0.0.1"), newTestIPClaim(als, 2, "10.0.0.3")}
+			pods := &corev1.PodList{Items: []corev1.Pod{*newTestPod(als, 0, "10.0.0.1", true)}}
+			if test.pod != nil {
+				pods.Items = append(pods.Items, *test.pod(als))
+			}
+			r := newTestReconciler(t, objs...)
+			backend, err := r.getBackend(als)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			done, requeueAfter, err := r.tearDownIPs(als, pods, backend, als.Spec.Replicas)
+			if err != nil {
+				t.Fatalf("Failed to tear down IPs, since: %v", err)
+			}
+			if done {
+				t.Errorf("expected teardown not done, since claim existed when it started")
+			}
+			if (requeueAfter > 0) != test.requeueAfter {
+				t.Errorf("expected requeue after quarantine %v, got %v", test.requeueAfter, requeueAfter)
+			}
+			if len(als.Status.Teardowns) != 1 || als.Status.Teardowns[0].Phase != test.phase {
+				t.Errorf("expected teardown phase %s, got %v", test.phase, als.Status.Teardowns)
+			}
+			if kept := getTestIPClaim(t, r, getPodName(als, 2)) != nil; kept != test.claimKept {
+				t.Errorf("expected claim kept %v, got %v", test.claimKept, kept)
+			}
+			if getTestIPClaim(t, r, getPodName(als, 0)) == nil {
+				t.Errorf("claim of index in replicas should never be released")
+			}
+		})
+	}
+}
+
+func TestIPRetentionPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy alcorv1alpha1.IPRetentionPolicyType
+		// expected after scaling down from 3 to 2
+		retainedIPs []string
+		claimedIPs  []string
+		// whether pod 2 gets its IP back at once after scaling up again
+		reused bool
+	}{
+		{name: "default", retainedIPs: []string{"10.0.0.3"}, claimedIPs: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, reused: true},
+		{name: "Retain", policy: alcorv1alpha1.RetainIPRetentionPolicy,
+			retainedIPs: []string{"10.0.0.3"}, claimedIPs: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, reused: true},
+		{name: "Release", policy: alcorv1alpha1.ReleaseIPRetentionPolicy, claimedIPs: []string{"10.0.0.1", "10.0.0.2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(2)
+			als.Spec.IPRetentionPolicy = test.policy
+			objs := []runtime.Object{als}
+			for idx := 0; idx != 3; idx++ {
+				if idx < 2 {
+					objs = append(objs, newTestPod(als, idx, testIP(idx), true))
+				}
+				objs = append(objs, newTestIPClaim(als, idx, testIP(idx)))
+			}
+			r := newTestReconciler(t, objs...)
+
+			// pod 2 is gone after scaling down
+			if _, err := r.releaseIdleIPs(als); err != nil {
+				t.Fatalf("Failed to release idle IPs, since: %v", err)
+			}
+			if err := r.syncStatus(als, als.Status.DeepCopy(), nil); err != nil {
+				t.Fatalf("Failed to sync status, since: %v", err)
+			}
+			if !reflect.DeepEqual(als.Status.RetainedIPs, test.retainedIPs) {
+				t.Errorf("expected retained IPs %v, got %v", test.retainedIPs, als.Status.RetainedIPs)
+			}
+			if !reflect.DeepEqual(als.Status.ClaimedIPs, test.claimedIPs) {
+				t.Errorf("expected claimed IPs %v, got %v", test.claimedIPs, als.Status.ClaimedIPs)
+			}
+
+			// scale up to 3
+			als.Spec.Replicas = 3
+			if _, err := r.createPod(als, listTestPods(t, r, als), []int{2}); err != nil {
+				t.Fatalf("Failed to create pod, since: %v", err)
+			}
+			pod, created := getPodMap(listTestPods(t, r, als))[getPodName(als, 2)]
+			if created != test.reused {
+				t.Fatalf("expected pod 2 created at once %v, got %v", test.reused, created)
+			}
+			if created && pod.Annotations[CalicoAnnotationKey] != `["10.0.0.3"]` {
+				t.Errorf("expected pod 2 reusing IP 10.0.0.3, got %s", pod.Annotations[CalicoAnnotationKey])
+			}
+			if claim := getTestIPClaim(t, r, getPodName(als, 2)); !test.reused && (claim == nil || claim.Status.IP != "") {
+				t.Errorf("expected a new claim for pod 2 waiting for IP, got %v", claim)
+			}
+			assertNoSharedIPs(t, r, als)
+		})
+	}
+}
+
+func TestGetClaimedIPOfClaimBeingDeleted(t *testing.T) {
+	als := newTestAlcorSet(3)
+	claim := newTestIPClaim(als, 2, "10.0.0.3")
+	now := metav1.Now()
+	claim.DeletionTimestamp = &now
+	claim.Finalizers = []string{"ipclaim.alcor.io"}
+	r := newTestReconciler(t, als, claim)
+	backend, err := r.getBackend(als)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := backend.GetClaimedIP(als, 2)
+	if err != nil {
+		t.Fatalf("Failed to get claimed IP, since: %v", err)
+	}
+	if claimed != nil {
+		t.Errorf("expected no IP from claim being deleted, got %s", claimed.ip)
+	}
+	if err := backend.ClaimIP(als, 2); err != nil {
+		t.Fatalf("Failed to claim IP, since: %v", err)
+	}
+	if found := getTestIPClaim(t, r, getPodName(als, 2)); found == nil || found.DeletionTimestamp == nil {
+		t.Errorf("expected claim being deleted not replaced, got %v", found)
+	}
+}
+
+// TestScaleDownAndUpNeverSharesIP scales down with Release, and scales up again while the IP of the
+// scaled down pod is in quarantine and after it's released. No two pods should use the same IP at any step.
+func TestScaleDownAndUpNeverSharesIP(t *testing.T) {
+	als := newTestAlcorSet(3)
+	als.Spec.IPRetentionPolicy = alcorv1alpha1.ReleaseIPRetentionPolicy
+	als.Spec.IPQuarantineSeconds = 60
+	objs := []runtime.Object{als}
+	for idx := 0; idx != 3; idx++ {
+		objs = append(objs, newTestPod(als, idx, testIP(idx), true), newTestIPClaim(als, idx, testIP(idx)))
+	}
+	r := newTestReconciler(t, objs...)
+	assertNoSharedIPs(t, r, als)
+
+	scaleDown := func() time.Duration {
+		t.Helper()
+		als.Spec.Replicas = 2
+		if err := r.tearDownPods(als, listTestPods(t, r, als), false); err != nil {
+			t.Fatalf("Failed to tear down pods, since: %v", err)
+		}
+		requeueAfter, err := r.releaseIdleIPs(als)
+		if err != nil {
+			t.Fatalf("Failed to release idle IPs, since: %v", err)
+		}
+		assertNoSharedIPs(t, r, als)
+		return requeueAfter
+	}
+	scaleUp := func() {
+		t.Helper()
+		als.Spec.Replicas = 3
+		if _, err := r.createPod(als, listTestPods(t, r, als), []int{2}); err != nil {
+			t.Fatalf("Failed to create pod, since: %v", err)
+		}
+		if _, err := r.releaseIdleIPs(als); err != nil {
+			t.Fatalf("Failed to release idle IPs, since: %v", err)
+		}
+		assertNoSharedIPs(t, r, als)
+	}
+
+	// scale down to 2, pod 2 is gone and its IP is in quarantine
+	if requeueAfter := scaleDown(); requeueAfter == 0 {
+		t.Errorf("expected requeue after quarantine")
+	}
+	if getTestIPClaim(t, r, getPodName(als, 2)) == nil {
+		t.Fatalf("expected claim kept in quarantine")
+	}
+
+	// scale up to 3 in quarantine, pod 2 gets its own IP back and teardown is dropped
+	scaleUp()
+	pod, ok := getPodMap(listTestPods(t, r, als))[getPodName(als, 2)]
+	if !ok || pod.Annotations[CalicoAnnotationKey] != `["10.0.0.3"]` {
+		t.Fatalf("expected pod 2 recreated with IP 10.0.0.3, got %v", pod.Annotations)
+	}
+	if len(als.Status.Teardowns) != 0 {
+		t.Errorf("expected teardown dropped after scaling up, got %v", als.Status.Teardowns)
+	}
+
+	// scale down to 2 again, and claim is released once quarantine passed
+	scaleDown()
+	als.Status.Teardowns[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	if _, err := r.releaseIdleIPs(als); err != nil {
+		t.Fatalf("Failed to release idle IPs, since: %v", err)
+	}
+	if getTestIPClaim(t, r, getPodName(als, 2)) != nil {
+		t.Fatalf("expected claim released after quarantine")
+	}
+
+	// scale up to 3, pod 2 waits for a new claim to get a new IP
+	scaleUp()
+	if len(listTestPods(t, r, als).Items) != 2 {
+		t.Fatalf("pod 2 should not be created before new claim gets IP")
+	}
+	newClaim := getTestIPClaim(t, r, getPodName(als, 2))
+	if newClaim == nil || newClaim.Status.IP != "" {
+		t.Fatalf("expected new claim for pod 2 waiting for IP, got %v", newClaim)
+	}
+	newClaim.Status.IP = "10.0.0.4"
+	if err := r.client.Update(context.TODO(), newClaim); err != nil {
+		t.Fatal(err)
+	}
+	scaleUp()
+	if len(listTestPods(t, r, als).Items) != 3 {
+		t.Fatalf("expected pod 2 created")
+	}
+}
@@ -0,0 +1,85 @@
+package alcorset
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// OutOfServiceTaintKey is taint marks node is fenced, pods on it will not run any more
+	OutOfServiceTaintKey = "node.kubernetes.io/out-of-service"
+
+	// unreachableNodeCheckInterval is interval to check node of pod which has timed out in terminating,
+	// since nodes are not watched
+	unreachableNodeCheckInterval = 30 * time.Second
+)
+
+// forceDeleteUnreachablePods force deletes pods stuck in terminating longer than timeout of unreachableNodePolicy.
+// Pod is only force deleted when its node is deleted or tainted out-of-service, so its IP will not be used
+// by recreated pod while it's still running. Returns duration to check pods again, 0 means no need.
+func (r *ReconcileAlcorSet) forceDeleteUnreachablePods(als *alcorv1alpha1.AlcorSet) (time.Duration, error) {
+	policy := als.Spec.UnreachableNodePolicy
+	if policy == nil {
+		return 0, nil
+	}
+	pods, err := r.listPods(als)
+	if err != nil {
+		return 0, err
+	}
+
+	var requeueAfter time.Duration
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp == nil || pod.Spec.NodeName == "" {
+			continue
+		}
+		// deletionTimestamp is already the time grace period ends
+		deadline := pod.DeletionTimestamp.Add(time.Duration(policy.TimeoutSeconds) * time.Second)
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			fenced, reason, err := r.isNodeFenced(pod.Spec.NodeName)
+			if err != nil {
+				return 0, err
+			}
+			if fenced {
+				alcorSetLogger(als).Info("Force deleting pod on unreachable node", "ordinal", getIndexByName(pod.Name),
+					"pod", pod.Name, "node", pod.Spec.NodeName, "reason", reason)
+				if err := r.client.Delete(context.TODO(), pod, client.GracePeriodSeconds(0)); err != nil && !errors.IsNotFound(err) {
+					return 0, fmt.Errorf("Failed to force delete pod %s, since: %v", pod.Name, err)
+				}
+				r.recordPodEvent(als, pod, corev1.EventTypeWarning, ReasonPodForceDeleted,
+					"Pod %s stuck in terminating is force deleted, since node %s %s", pod.Name, pod.Spec.NodeName, reason)
+				continue
+			}
+			wait = unreachableNodeCheckInterval
+		}
+		if requeueAfter == 0 || wait < requeueAfter {
+			requeueAfter = wait
+		}
+	}
+	return requeueAfter, nil
+}
+
+// isNodeFenced returns whether node is deleted or tainted out-of-service, with reason
+func (r *ReconcileAlcorSet) isNodeFenced(nodeName string) (bool, string, error) {
+	node := &corev1.Node{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: nodeName}, node); err != nil {
+		if errors.IsNotFound(err) {
+			return true, "is deleted", nil
+		}
+		return false, "", err
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == OutOfServiceTaintKey {
+			return true, "is tainted " + OutOfServiceTaintKey, nil
+		}
+	}
+	return false, "", nil
+}
@@ -2,6 +2,7 @@ package alcorset
 
 import (
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -12,6 +13,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 const (
@@ -27,6 +29,10 @@ const (
 	// to release IPs.
 	FinalizerVPCIPClaim = "vpcipclaim.finalizer.alcorset.alcor.io"
 
+	// VPCBackend is network backend for VPC, IPs are claimed by VPCIPClaim
+	VPCBackend = "vpc"
+	// SriovBackend is network backend for SR-IOV, IPs are setup by saishang
+	SriovBackend = "sriov"
 	// CalicoBackend is ippool backend for calico
 	CalicoBackend = "calico"
 	// CalicoAnnotationKey is annotation key to specify IP in calico
@@ -35,13 +41,22 @@ const (
 	PodNameIndexSep = "-"
 	// AlcorSetAppLabel is label for resources owned by AlcorSet
 	AlcorSetAppLabel = "app.alcorset.alcor.io"
-	// AlcorSetSpecLabel will have a value with md5 of spec pod used to create
+	// AlcorSetSpecLabel will have a value with sha256(truncated) of pod template used to create pod
 	AlcorSetSpecLabel = "spec.alcorset.alcor.io"
+	// AlcorSetOrphanLabel marks IPClaim or VPCIPClaim is left by deleted AlcorSet, to be adopted
+	AlcorSetOrphanLabel = "orphan.alcorset.alcor.io"
+	// AlcorSetStageLabel marks pod is created by stage pod template
+	AlcorSetStageLabel = "stage.alcorset.alcor.io"
+	// specHashLength is length of AlcorSetSpecLabel value, label value should be no more than 63 characters
+	specHashLength = 10
 
-	StatusFailedToClaimIP    = "Failed to claim IP"
-	StatusIPClaimNotReady    = "IPClaim not ready"
-	StatusPodDeletedNotFound = "Pod deleted not found"
-	StatusWaitIPClaimReady   = "Wait IPClaim ready"
+	StatusFailedToClaimIP  = "Failed to claim IP"
+	StatusWaitIPClaimReady = "Wait IPClaim ready"
+	StatusNotEnoughIPs     = "Not enough IPs for replicas"
+	StatusDegraded         = "Degraded"
+	StatusProgressing      = "Progressing"
+	StatusPodsNotReady     = "Pods not ready"
+	StatusReady            = "Ready"
 )
 
 var (
@@ -61,6 +76,32 @@ func getPodHostname(alcorset *alcor.AlcorSet, podIdx int) string {
 	return fmt.Sprintf("%s%s%d", alcorset.Spec.HostnamePrefix, PodNameIndexSep, podIdx)
 }
 
+// getNetworkBackend returns network backend of AlcorSet, onVpc always means VPC
+func getNetworkBackend(als *alcor.AlcorSet) string {
+	if als.Spec.OnVPC || als.Spec.NetworkBackend == VPCBackend {
+		return VPCBackend
+	}
+	if als.Spec.NetworkBackend == "" {
+		return SriovBackend
+	}
+	return als.Spec.NetworkBackend
+}
+
+func onVPC(als *alcor.AlcorSet) bool {
+	return getNetworkBackend(als) == VPCBackend
+}
+
+// checkFixedIPs returns a status if fixed IPs cannot be used by AlcorSet
+func checkFixedIPs(als *alcor.AlcorSet) string {
+	if len(als.Spec.IPs) == 0 {
+		return ""
+	}
+	if als.Spec.Replicas > len(als.Spec.IPs) {
+		return StatusNotEnoughIPs
+	}
+	return ""
+}
+
 func getIndexByName(podName string) int {
 	fields := strings.Split(podName, PodNameIndexSep)
 	idx, _ := strconv.Atoi(fields[len(fields)-1])
@@ -95,10 +136,105 @@ func getPodMap(pods *corev1.PodList) map[string]corev1.Pod {
 
 func asSha256(o interface{}) string {
 	h := sha256.New()
-	h.Write([]byte(fmt.Sprintf("%v", o)))
+	// %v prints pointers as addresses, so hash json instead
+	data, err := json.Marshal(o)
+	if err != nil {
+		data = []byte(fmt.Sprintf("%v", o))
+	}
+	h.Write(data)
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
+// getTemplateHash returns hash of pod template, with subdomain set by serviceName if it's set,
+// so pods are recreated with new subdomain after serviceName changes
+func getTemplateHash(als *alcor.AlcorSet, template *corev1.PodTemplateSpec) string {
+	if als.Spec.ServiceName != "" {
+		template = template.DeepCopy()
+		template.Spec.Subdomain = als.Spec.ServiceName
+	}
+	return asSha256(template)[:specHashLength]
+}
+
+func inStage(als *alcor.AlcorSet, podIdx int) bool {
+	return als.Spec.StagePodTemplateSpec != nil && podIdx < als.Spec.StageReplicas
+}
+
+func getPodTemplate(als *alcor.AlcorSet, podIdx int) *corev1.PodTemplateSpec {
+	if inStage(als, podIdx) {
+		return als.Spec.StagePodTemplateSpec
+	}
+	return &als.Spec.PodTemplateSpec
+}
+
+// getMissingIndexes returns indexes in [0, replicas) without pod, in ascending order
+func getMissingIndexes(als *alcor.AlcorSet, pods *corev1.PodList) []int {
+	podMap := getPodMap(pods)
+	missing := []int{}
+	for idx := 0; idx != als.Spec.Replicas; idx++ {
+		if _, ok := podMap[getPodName(als, idx)]; !ok {
+			missing = append(missing, idx)
+		}
+	}
+	return missing
+}
+
+// hasPodsBeyondReplicas returns whether there are pods with index no less than replicas
+func hasPodsBeyondReplicas(als *alcor.AlcorSet, pods *corev1.PodList) bool {
+	for _, pod := range pods.Items {
+		if getIndexByName(pod.Name) >= als.Spec.Replicas {
+			return true
+		}
+	}
+	return false
+}
+
+// getLastNodeMessage returns node where pod with given index was last seen, from status.members
+func getLastNodeMessage(als *alcor.AlcorSet, idx int) string {
+	for _, member := range als.Status.Members {
+		if member.Ordinal == idx && member.NodeName != "" {
+			return fmt.Sprintf(", it was last seen on node %s", member.NodeName)
+		}
+	}
+	return ""
+}
+
+// getIPRetentionPolicy returns ipRetentionPolicy, default is Retain
+func getIPRetentionPolicy(als *alcor.AlcorSet) alcor.IPRetentionPolicyType {
+	if als.Spec.IPRetentionPolicy == "" {
+		return alcor.RetainIPRetentionPolicy
+	}
+	return als.Spec.IPRetentionPolicy
+}
+
+// getPodManagementPolicy returns podManagementPolicy, for AlcorSet without it, sequence means OrderedReady
+func getPodManagementPolicy(als *alcor.AlcorSet) alcor.PodManagementPolicyType {
+	if als.Spec.PodManagementPolicy != "" {
+		return als.Spec.PodManagementPolicy
+	}
+	if als.Spec.Sequence {
+		return alcor.OrderedReadyPodManagement
+	}
+	return alcor.ParallelPodManagement
+}
+
+// inSequence returns whether pods are created and deleted one by one
+func inSequence(als *alcor.AlcorSet) bool {
+	return getPodManagementPolicy(als) == alcor.OrderedReadyPodManagement
+}
+
+// getMaxUnavailable returns number of pods allowed to be unavailable during rolling update,
+// in sequence case, pods are updated one by one
+func getMaxUnavailable(als *alcor.AlcorSet) int {
+	if inSequence(als) || als.Spec.MaxUnavailable == nil {
+		return 1
+	}
+	maxUnavailable, err := intstr.GetValueFromIntOrPercent(als.Spec.MaxUnavailable, als.Spec.Replicas, false)
+	if err != nil || maxUnavailable < 1 {
+		return 1
+	}
+	return maxUnavailable
+}
+
 func newOwnerReference(als *alcor.AlcorSet) *metav1.OwnerReference {
 	blockOwnerDeletion := true
 	controller := true
@@ -147,20 +283,24 @@ func newVPCIPClaimForCR(als *alcor.AlcorSet, name string) *vpcipclaim.VPCIPClaim
 }
 
 func newPodForCR(als *alcor.AlcorSet, name, hostname string, inStage bool, annotations map[string]string) *corev1.Pod {
+	template := als.Spec.PodTemplateSpec.DeepCopy()
+	if inStage {
+		template = als.Spec.StagePodTemplateSpec.DeepCopy()
+	}
+	specHash := getTemplateHash(als, template)
 	metadata := metav1.ObjectMeta{
 		Name:        name,
 		Namespace:   als.Namespace,
-		Labels:      als.Spec.PodTemplateSpec.Labels,
-		Annotations: als.Spec.PodTemplateSpec.Annotations,
+		Labels:      template.Labels,
+		Annotations: template.Annotations,
 	}
-	if app, ok := metadata.Labels[AlcorSetAppLabel]; !ok || app != als.Name {
-		if metadata.Labels == nil {
-			metadata.Labels = map[string]string{
-				AlcorSetAppLabel: als.Name,
-			}
-		} else {
-			metadata.Labels[AlcorSetAppLabel] = als.Name
-		}
+	if metadata.Labels == nil {
+		metadata.Labels = map[string]string{}
+	}
+	metadata.Labels[AlcorSetAppLabel] = als.Name
+	metadata.Labels[AlcorSetSpecLabel] = specHash
+	if inStage {
+		metadata.Labels[AlcorSetStageLabel] = "true"
 	}
 	if metadata.Annotations == nil {
 		metadata.Annotations = annotations
@@ -169,8 +309,12 @@ func newPodForCR(als *alcor.AlcorSet, name, hostname string, inStage bool, annot
 			metadata.Annotations[k] = v
 		}
 	}
-	podSpec := als.Spec.PodTemplateSpec.Spec
+	podSpec := template.Spec
 	podSpec.Hostname = hostname
+	if als.Spec.ServiceName != "" {
+		podSpec.Subdomain = als.Spec.ServiceName
+	}
+	addPVCVolumes(als, &podSpec, name)
 	return &corev1.Pod{
 		ObjectMeta: metadata,
 		Spec:       podSpec,
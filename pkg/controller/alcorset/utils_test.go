@@ -0,0 +1,37 @@
+package alcorset
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGetMissingIndexes(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas int
+		existing []int
+		missing  []int
+	}{
+		{name: "no pods", replicas: 3, existing: nil, missing: []int{0, 1, 2}},
+		{name: "no gap", replicas: 3, existing: []int{0, 1, 2}, missing: []int{}},
+		{name: "gap at head", replicas: 4, existing: []int{1, 2, 3}, missing: []int{0}},
+		{name: "gap in middle", replicas: 4, existing: []int{0, 1, 3}, missing: []int{2}},
+		{name: "gap at tail", replicas: 4, existing: []int{0, 1}, missing: []int{2, 3}},
+		{name: "gaps everywhere", replicas: 6, existing: []int{1, 3}, missing: []int{0, 2, 4, 5}},
+		{name: "pods beyond replicas", replicas: 2, existing: []int{1, 2, 3}, missing: []int{0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(test.replicas)
+			pods := &corev1.PodList{}
+			for _, idx := range test.existing {
+				pods.Items = append(pods.Items, *newTestPod(als, idx, "", true))
+			}
+			if missing := getMissingIndexes(als, pods); !reflect.DeepEqual(missing, test.missing) {
+				t.Errorf("expected missing %v, got %v", test.missing, missing)
+			}
+		})
+	}
+}
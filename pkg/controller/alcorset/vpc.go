@@ -0,0 +1,251 @@
+package alcorset
+
+import (
+	"context"
+	"fmt"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	"github.com/onionpiece/vpcapi"
+	vpcipclaim "github.com/onionpiece/vpcipclaim/pkg/apis/alcor/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// vpcBackend claims IPs by VPCIPClaim, which are named after pods. For IPs in spec.ips, VPCIPClaims
+// pinning them should be created before pods, since NICs of IPs are setup by VPCIPClaim. Pinned claims
+// are only used, they are never created, released, orphaned or adopted by AlcorSet.
+type vpcBackend struct {
+	client client.Client
+}
+
+func newVPCBackend(c client.Client) networkBackend {
+	return &vpcBackend{client: c}
+}
+
+func (b *vpcBackend) Finalizer() string {
+	return FinalizerVPCIPClaim
+}
+
+func (b *vpcBackend) ClaimIP(als *alcorv1alpha1.AlcorSet, podIdx int) error {
+	if len(als.Spec.IPs) > 0 {
+		return b.checkPinnedClaim(als, podIdx)
+	}
+	podName := getPodName(als, podIdx)
+	vpcIPClaimRef := &vpcipclaim.VPCIPClaim{}
+	err := b.client.Get(context.TODO(), types.NamespacedName{Name: podName, Namespace: als.Namespace}, vpcIPClaimRef)
+	if err == nil && isOrphaned(vpcIPClaimRef) {
+		return fmt.Errorf("vpcipclaim %s is orphaned and not adopted, set ipAdoption to adopt it, or delete it", podName)
+	}
+	if err == nil || !errors.IsNotFound(err) {
+		// existing claim is not recreated, even if it's being deleted, until it's gone
+		return err
+	}
+	alcorSetLogger(als).Info("Creating a new VPCIPClaim", "ordinal", podIdx, "claim", podName)
+	newVPCIPClaim := newVPCIPClaimForCR(als, podName)
+	if err := b.client.Create(context.TODO(), newVPCIPClaim); err != nil {
+		return fmt.Errorf("Fail to create vpcipclaim, since: %v", err)
+	}
+	return nil
+}
+
+// checkPinnedClaim checks VPCIPClaim for pod with given index pins IPs[N], nothing happens if claim is
+// not ready yet
+func (b *vpcBackend) checkPinnedClaim(als *alcorv1alpha1.AlcorSet, podIdx int) error {
+	if podIdx >= len(als.Spec.IPs) {
+		return fmt.Errorf("No fixed IP for pod with index %d", podIdx)
+	}
+	podName := getPodName(als, podIdx)
+	ip := als.Spec.IPs[podIdx]
+	vpcIPClaimRef := &vpcipclaim.VPCIPClaim{}
+	if err := b.client.Get(context.TODO(), types.NamespacedName{Name: podName, Namespace: als.Namespace}, vpcIPClaimRef); err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("vpcipclaim %s pinning fixed IP %s not found, it should be created before pod", podName, ip)
+		}
+		return err
+	}
+	if vpcIPClaimRef.Status.IP != "" && vpcIPClaimRef.Status.IP != ip {
+		return fmt.Errorf("vpcipclaim %s has IP %s, not fixed IP %s", podName, vpcIPClaimRef.Status.IP, ip)
+	}
+	return nil
+}
+
+func (b *vpcBackend) GetClaimedIP(als *alcorv1alpha1.AlcorSet, podIdx int) (*claimedIP, error) {
+	if len(als.Spec.IPs) > 0 && podIdx >= len(als.Spec.IPs) {
+		return nil, nil
+	}
+	podName := getPodName(als, podIdx)
+	vpcIPClaimRef := &vpcipclaim.VPCIPClaim{}
+	if err := b.client.Get(context.TODO(), types.NamespacedName{Name: podName, Namespace: als.Namespace}, vpcIPClaimRef); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if vpcIPClaimRef.DeletionTimestamp != nil || isOrphaned(vpcIPClaimRef) || vpcIPClaimRef.Status.IP == "" {
+		return nil, nil
+	}
+	if len(als.Spec.IPs) > 0 && vpcIPClaimRef.Status.IP != als.Spec.IPs[podIdx] {
+		// pinned claim doesn't have fixed IP of pod
+		return nil, nil
+	}
+	return &claimedIP{
+		ip:         vpcIPClaimRef.Status.IP,
+		claimName:  vpcIPClaimRef.Name,
+		nicID:      vpcIPClaimRef.Status.InterfaceID,
+		nicMAC:     vpcIPClaimRef.Status.InterfaceMACAddress,
+		instanceID: vpcIPClaimRef.Status.InstanceID,
+		createdAt:  vpcIPClaimRef.CreationTimestamp,
+	}, nil
+}
+
+func (b *vpcBackend) PodAnnotations(als *alcorv1alpha1.AlcorSet, claimed *claimedIP) (map[string]string, error) {
+	return map[string]string{
+		vpcapi.AnnoKeyVPCIP:         claimed.ip,
+		vpcapi.AnnoKeyVPCNICMAC:     claimed.nicMAC,
+		vpcapi.AnnoKeyVPCNICID:      claimed.nicID,
+		vpcapi.AnnoKeyVPCInstanceID: claimed.instanceID,
+		vpcapi.AnnoKeyVPCIPRetain:   "true",
+	}, nil
+}
+
+func (b *vpcBackend) PodIP(pod *corev1.Pod) string {
+	return pod.Annotations[vpcapi.AnnoKeyVPCIP]
+}
+
+func (b *vpcBackend) Release(als *alcorv1alpha1.AlcorSet) ([]string, error) {
+	if len(als.Spec.IPs) > 0 {
+		return nil, nil
+	}
+	reqLogger := alcorSetLogger(als)
+	reqLogger.Info("Deleting VPCIPClaims")
+	vpcipclaims := &vpcipclaim.VPCIPClaimList{}
+	opts := []client.ListOption{
+		client.InNamespace(als.Namespace),
+		client.MatchingLabels{AlcorSetAppLabel: als.Name},
+	}
+	if err := b.client.List(context.TODO(), vpcipclaims, opts...); err != nil {
+		if errors.IsNotFound(err) {
+			reqLogger.Info("No vpcipclaims found, consider the resources are deleted")
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Failed to release vpcipclaims, found error when list vpcipclaims: %v", err)
+	}
+	releasedIPs := []string{}
+	for _, vpcipclaim := range vpcipclaims.Items {
+		if isOrphaned(&vpcipclaim) {
+			// left by AlcorSet deleted before
+			continue
+		}
+		reqLogger.V(1).Info("To delete vpcipclaim", "claim", vpcipclaim.Name)
+		if err := b.client.Delete(context.TODO(), &vpcipclaim); err != nil {
+			return releasedIPs, fmt.Errorf("Failed to release vpcipclaims, found error when delete vpcipclaim: %v", err)
+		}
+		releasedIPs = append(releasedIPs, vpcipclaim.Status.IP)
+	}
+	return releasedIPs, nil
+}
+
+func (b *vpcBackend) ListClaimedIPs(als *alcorv1alpha1.AlcorSet) (map[int]*claimedIP, error) {
+	if len(als.Spec.IPs) > 0 {
+		return map[int]*claimedIP{}, nil
+	}
+	vpcipclaims := &vpcipclaim.VPCIPClaimList{}
+	opts := []client.ListOption{
+		client.InNamespace(als.Namespace),
+		client.MatchingLabels{AlcorSetAppLabel: als.Name},
+	}
+	if err := b.client.List(context.TODO(), vpcipclaims, opts...); err != nil {
+		return nil, fmt.Errorf("Failed to list vpcipclaims, since: %v", err)
+	}
+	claimed := map[int]*claimedIP{}
+	for _, vpcIPClaimRef := range vpcipclaims.Items {
+		idx := getIndexByName(vpcIPClaimRef.Name)
+		if vpcIPClaimRef.Name != getPodName(als, idx) || vpcIPClaimRef.DeletionTimestamp != nil || isOrphaned(&vpcIPClaimRef) {
+			continue
+		}
+		claimed[idx] = &claimedIP{
+			ip:         vpcIPClaimRef.Status.IP,
+			claimName:  vpcIPClaimRef.Name,
+			nicID:      vpcIPClaimRef.Status.InterfaceID,
+			nicMAC:     vpcIPClaimRef.Status.InterfaceMACAddress,
+			instanceID: vpcIPClaimRef.Status.InstanceID,
+			createdAt:  vpcIPClaimRef.CreationTimestamp,
+		}
+	}
+	return claimed, nil
+}
+
+func (b *vpcBackend) ReleaseIP(als *alcorv1alpha1.AlcorSet, podIdx int) (string, error) {
+	if len(als.Spec.IPs) > 0 {
+		return "", nil
+	}
+	vpcIPClaimRef := &vpcipclaim.VPCIPClaim{}
+	if err := b.client.Get(context.TODO(), types.NamespacedName{Name: getPodName(als, podIdx), Namespace: als.Namespace}, vpcIPClaimRef); err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if isOrphaned(vpcIPClaimRef) {
+		// left by AlcorSet deleted before, kept for adoption
+		return "", nil
+	}
+	if err := b.client.Delete(context.TODO(), vpcIPClaimRef); err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("Failed to release vpcipclaim %s, since: %v", vpcIPClaimRef.Name, err)
+	}
+	return vpcIPClaimRef.Status.IP, nil
+}
+
+func (b *vpcBackend) Orphan(als *alcorv1alpha1.AlcorSet) ([]string, error) {
+	if len(als.Spec.IPs) > 0 {
+		return nil, nil
+	}
+	vpcipclaims := &vpcipclaim.VPCIPClaimList{}
+	opts := []client.ListOption{
+		client.InNamespace(als.Namespace),
+		client.MatchingLabels{AlcorSetAppLabel: als.Name},
+	}
+	if err := b.client.List(context.TODO(), vpcipclaims, opts...); err != nil {
+		return nil, fmt.Errorf("Failed to list vpcipclaims, since: %v", err)
+	}
+	orphanedIPs := []string{}
+	for i := range vpcipclaims.Items {
+		vpcIPClaimRef := &vpcipclaims.Items[i]
+		if !orphanObject(vpcIPClaimRef, als) {
+			continue
+		}
+		if err := b.client.Update(context.TODO(), vpcIPClaimRef); err != nil {
+			return orphanedIPs, fmt.Errorf("Failed to orphan vpcipclaim %s, since: %v", vpcIPClaimRef.Name, err)
+		}
+		orphanedIPs = append(orphanedIPs, vpcIPClaimRef.Status.IP)
+	}
+	return orphanedIPs, nil
+}
+
+func (b *vpcBackend) Adopt(als *alcorv1alpha1.AlcorSet) ([]string, error) {
+	if len(als.Spec.IPs) > 0 {
+		return nil, nil
+	}
+	vpcipclaims := &vpcipclaim.VPCIPClaimList{}
+	opts := []client.ListOption{
+		client.InNamespace(als.Namespace),
+		client.MatchingLabels{AlcorSetAppLabel: als.Name, AlcorSetOrphanLabel: "true"},
+	}
+	if err := b.client.List(context.TODO(), vpcipclaims, opts...); err != nil {
+		return nil, fmt.Errorf("Failed to list vpcipclaims, since: %v", err)
+	}
+	adoptedIPs := []string{}
+	for i := range vpcipclaims.Items {
+		vpcIPClaimRef := &vpcipclaims.Items[i]
+		if !adoptObject(vpcIPClaimRef, als) {
+			continue
+		}
+		if err := b.client.Update(context.TODO(), vpcIPClaimRef); err != nil {
+			return adoptedIPs, fmt.Errorf("Failed to adopt vpcipclaim %s, since: %v", vpcIPClaimRef.Name, err)
+		}
+		adoptedIPs = append(adoptedIPs, vpcIPClaimRef.Status.IP)
+	}
+	return adoptedIPs, nil
+}
@@ -0,0 +1,98 @@
+package alcorset
+
+import (
+	"context"
+	"testing"
+
+	vpcipclaim "github.com/onionpiece/vpcipclaim/pkg/apis/alcor/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestVPCPinnedClaim(t *testing.T) {
+	tests := []struct {
+		name string
+		// IP of VPCIPClaim created before pod 1, nil means no claim
+		claimIP  *string
+		claimErr bool
+		// expected claimed IP of pod 1, empty means not ready
+		claimed string
+	}{
+		{name: "claim not created", claimErr: true},
+		{name: "claim not ready", claimIP: strPtr("")},
+		{name: "claim has another IP", claimIP: strPtr("10.0.0.9"), claimErr: true},
+		{name: "claim pins fixed IP", claimIP: strPtr("10.0.0.2"), claimed: "10.0.0.2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(2)
+			als.Spec.IPPool = ""
+			als.Spec.NetworkBackend = VPCBackend
+			als.Spec.IPs = []string{"10.0.0.1", "10.0.0.2"}
+			objs := []runtime.Object{als}
+			if test.claimIP != nil {
+				// created by user, not owned by AlcorSet
+				claim := &vpcipclaim.VPCIPClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: getPodName(als, 1), Namespace: als.Namespace},
+					Spec:       vpcipclaim.VPCIPClaimSpec{Pod: getPodName(als, 1)},
+				}
+				claim.Status.IP = *test.claimIP
+				objs = append(objs, claim)
+			}
+			r := newTestReconciler(t, objs...)
+			backend, err := r.getBackend(als)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := backend.ClaimIP(als, 1); (err != nil) != test.claimErr {
+				t.Errorf("expected error %v when claiming IP, got %v", test.claimErr, err)
+			}
+			claimed, err := backend.GetClaimedIP(als, 1)
+			if err != nil {
+				t.Fatalf("Failed to get claimed IP, since: %v", err)
+			}
+			ip := ""
+			if claimed != nil {
+				ip = claimed.ip
+			}
+			if ip != test.claimed {
+				t.Errorf("expected claimed IP %q, got %q", test.claimed, ip)
+			}
+
+			// pinned claims are never created or released by AlcorSet
+			claims, err := backend.ListClaimedIPs(als)
+			if err != nil {
+				t.Fatalf("Failed to list claimed IPs, since: %v", err)
+			}
+			if len(claims) != 0 {
+				t.Errorf("expected no claims listed, got %v", claims)
+			}
+			if _, err := backend.ReleaseIP(als, 1); err != nil {
+				t.Fatalf("Failed to release IP, since: %v", err)
+			}
+			if _, err := backend.Release(als); err != nil {
+				t.Fatalf("Failed to release IPs, since: %v", err)
+			}
+			found := &vpcipclaim.VPCIPClaimList{}
+			if err := r.client.List(context.TODO(), found); err != nil {
+				t.Fatal(err)
+			}
+			if expected := len(objs) - 1; len(found.Items) != expected {
+				t.Errorf("expected %d vpcipclaims, got %v", expected, found.Items)
+			}
+			if test.claimIP != nil {
+				claim := &vpcipclaim.VPCIPClaim{}
+				key := types.NamespacedName{Name: getPodName(als, 1), Namespace: als.Namespace}
+				if err := r.client.Get(context.TODO(), key, claim); err != nil || claim.DeletionTimestamp != nil {
+					t.Errorf("expected pinned claim kept, got %v, %v", claim, err)
+				}
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,10 @@
+package webhook
+
+import (
+	"github.com/onionpiece/alcorset/pkg/webhook/alcorset"
+)
+
+func init() {
+	// AddToManagerFuncs is a list of functions to create webhooks and add them to a manager.
+	AddToManagerFuncs = append(AddToManagerFuncs, alcorset.Add)
+}
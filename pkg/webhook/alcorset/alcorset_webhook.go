@@ -0,0 +1,107 @@
+package alcorset
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// MutatePath is path for mutating webhook of AlcorSet
+	MutatePath = "/mutate-alcor-io-v1alpha1-alcorset"
+	// ValidatePath is path for validating webhook of AlcorSet and its scale subresource
+	ValidatePath = "/validate-alcor-io-v1alpha1-alcorset"
+
+	scaleSubResource = "scale"
+)
+
+// Add creates AlcorSet webhooks and registers them to the webhook server of the Manager.
+func Add(mgr manager.Manager) error {
+	decoder, err := admission.NewDecoder(mgr.GetScheme())
+	if err != nil {
+		return err
+	}
+	hookServer := mgr.GetWebhookServer()
+	hookServer.Register(MutatePath, &webhook.Admission{Handler: &alcorSetDefaulter{decoder: decoder}})
+	hookServer.Register(ValidatePath, &webhook.Admission{Handler: &alcorSetValidator{decoder: decoder, reader: mgr.GetAPIReader()}})
+	return nil
+}
+
+// alcorSetDefaulter sets default values for AlcorSet
+type alcorSetDefaulter struct {
+	decoder *admission.Decoder
+}
+
+// blank assignment to verify that alcorSetDefaulter implements admission.Handler
+var _ admission.Handler = &alcorSetDefaulter{}
+
+func (d *alcorSetDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	als := &alcorv1alpha1.AlcorSet{}
+	if err := d.decoder.Decode(req, als); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	setDefaults(als)
+	marshaled, err := json.Marshal(als)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// alcorSetValidator rejects invalid AlcorSet, and scaling AlcorSet to invalid replicas
+type alcorSetValidator struct {
+	decoder *admission.Decoder
+	// reads AlcorSet being scaled from API server, cached one may be stale
+	reader client.Reader
+}
+
+// blank assignment to verify that alcorSetValidator implements admission.Handler
+var _ admission.Handler = &alcorSetValidator{}
+
+func (v *alcorSetValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.SubResource == scaleSubResource {
+		return v.handleScale(ctx, req)
+	}
+	als := &alcorv1alpha1.AlcorSet{}
+	if err := v.decoder.Decode(req, als); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	errs := validateAlcorSet(als)
+	if req.Operation == admissionv1beta1.Update {
+		old := &alcorv1alpha1.AlcorSet{}
+		if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		errs = append(errs, validateAlcorSetUpdate(old, als)...)
+	}
+	if len(errs) != 0 {
+		return admission.Denied(errs.ToAggregate().Error())
+	}
+	return admission.Allowed("")
+}
+
+// handleScale validates AlcorSet with replicas from scale subresource, e.g. replicas should not be more than spec.ips
+func (v *alcorSetValidator) handleScale(ctx context.Context, req admission.Request) admission.Response {
+	scale := &autoscalingv1.Scale{}
+	if err := v.decoder.Decode(req, scale); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	als := &alcorv1alpha1.AlcorSet{}
+	if err := v.reader.Get(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace}, als); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	als.Spec.Replicas = int(scale.Spec.Replicas)
+	if errs := validateAlcorSet(als); len(errs) != 0 {
+		return admission.Denied(errs.ToAggregate().Error())
+	}
+	return admission.Allowed("")
+}
@@ -0,0 +1,68 @@
+package alcorset
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/onionpiece/alcorset/pkg/apis"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestValidateScale(t *testing.T) {
+	tests := []struct {
+		name     string
+		ips      []string
+		replicas int32
+		allowed  bool
+	}{
+		{name: "scale within ips", ips: []string{"10.0.0.1", "10.0.0.2"}, replicas: 2, allowed: true},
+		{name: "scale beyond ips", ips: []string{"10.0.0.1", "10.0.0.2"}, replicas: 3},
+		{name: "scale down", ips: []string{"10.0.0.1", "10.0.0.2"}, replicas: 0, allowed: true},
+		{name: "scale with ippool", replicas: 5, allowed: true},
+		{name: "scale to negative", replicas: -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			if err := clientgoscheme.AddToScheme(s); err != nil {
+				t.Fatal(err)
+			}
+			if err := apis.AddToScheme(s); err != nil {
+				t.Fatal(err)
+			}
+			decoder, err := admission.NewDecoder(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			als := newTestAlcorSet(1)
+			als.Spec.IPs = test.ips
+			v := &alcorSetValidator{decoder: decoder, reader: fake.NewFakeClientWithScheme(s, als)}
+
+			raw, err := json.Marshal(&autoscalingv1.Scale{
+				TypeMeta:   metav1.TypeMeta{APIVersion: autoscalingv1.SchemeGroupVersion.String(), Kind: "Scale"},
+				ObjectMeta: metav1.ObjectMeta{Name: als.Name, Namespace: als.Namespace},
+				Spec:       autoscalingv1.ScaleSpec{Replicas: test.replicas},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := v.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+				Operation:   admissionv1beta1.Update,
+				Name:        als.Name,
+				Namespace:   als.Namespace,
+				SubResource: scaleSubResource,
+				Object:      runtime.RawExtension{Raw: raw},
+			}})
+			if resp.Allowed != test.allowed {
+				t.Errorf("expected allowed %v, got %v", test.allowed, resp.Result)
+			}
+		})
+	}
+}
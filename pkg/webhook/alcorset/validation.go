@@ -0,0 +1,174 @@
+package alcorset
+
+import (
+	"fmt"
+	"net"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	controller "github.com/onionpiece/alcorset/pkg/controller/alcorset"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// setDefaults sets default values for AlcorSet, hostnamePrefix defaults to AlcorSet name
+func setDefaults(als *alcorv1alpha1.AlcorSet) {
+	if als.Spec.HostnamePrefix == "" {
+		als.Spec.HostnamePrefix = als.Name
+	}
+}
+
+func validateAlcorSet(als *alcorv1alpha1.AlcorSet) field.ErrorList {
+	errs := field.ErrorList{}
+	specPath := field.NewPath("spec")
+
+	if als.Spec.Replicas < 0 {
+		errs = append(errs, field.Invalid(specPath.Child("replicas"), als.Spec.Replicas, "should not be negative"))
+	}
+	if als.Spec.Mbps < 0 {
+		errs = append(errs, field.Invalid(specPath.Child("mbps"), als.Spec.Mbps, "should not be negative"))
+	}
+	if als.Spec.StageReplicas < 0 {
+		errs = append(errs, field.Invalid(specPath.Child("stageReplicas"), als.Spec.StageReplicas, "should not be negative"))
+	}
+	if als.Spec.PodManagementPolicy == alcorv1alpha1.BurstPodManagement && als.Spec.BurstSize < 1 {
+		errs = append(errs, field.Invalid(specPath.Child("burstSize"), als.Spec.BurstSize,
+			"should be positive when podManagementPolicy is Burst"))
+	} else if als.Spec.BurstSize < 0 {
+		errs = append(errs, field.Invalid(specPath.Child("burstSize"), als.Spec.BurstSize, "should not be negative"))
+	}
+
+	if als.Spec.HostnamePrefix == "" {
+		errs = append(errs, field.Required(specPath.Child("hostnamePrefix"), ""))
+	} else {
+		// pod hostname is hostnamePrefix-N, check the longest one
+		maxIdx := als.Spec.Replicas - 1
+		if maxIdx < 0 {
+			maxIdx = 0
+		}
+		hostname := fmt.Sprintf("%s-%d", als.Spec.HostnamePrefix, maxIdx)
+		for _, msg := range validation.IsDNS1123Label(hostname) {
+			errs = append(errs, field.Invalid(specPath.Child("hostnamePrefix"), als.Spec.HostnamePrefix,
+				fmt.Sprintf("pod hostname %s is invalid: %s", hostname, msg)))
+		}
+	}
+
+	if als.Spec.ServiceName != "" {
+		for _, msg := range validation.IsDNS1035Label(als.Spec.ServiceName) {
+			errs = append(errs, field.Invalid(specPath.Child("serviceName"), als.Spec.ServiceName, msg))
+		}
+	}
+
+	if als.Spec.OnVPC && als.Spec.NetworkBackend != "" && als.Spec.NetworkBackend != controller.VPCBackend {
+		errs = append(errs, field.Invalid(specPath.Child("networkBackend"), als.Spec.NetworkBackend,
+			fmt.Sprintf("should be %s or empty when onVpc is true", controller.VPCBackend)))
+	}
+	onVPC := als.Spec.OnVPC || als.Spec.NetworkBackend == controller.VPCBackend
+	if onVPC && als.Spec.IPPool != "" {
+		errs = append(errs, field.Invalid(specPath.Child("ippool"), als.Spec.IPPool, "should not be set when onVpc is true"))
+	}
+	if len(als.Spec.IPs) > 0 {
+		ipsPath := specPath.Child("ips")
+		if als.Spec.Replicas > len(als.Spec.IPs) {
+			errs = append(errs, field.Invalid(specPath.Child("replicas"), als.Spec.Replicas,
+				fmt.Sprintf("should be smaller or equal to number of ips %d", len(als.Spec.IPs))))
+		}
+		// SR-IOV vlan, gateway and mask of fixed IPs can only be found in spec of ippool
+		sriov := als.Spec.NetworkBackend == controller.SriovBackend || (als.Spec.NetworkBackend == "" && !onVPC)
+		if sriov && als.Spec.IPPool == "" {
+			errs = append(errs, field.Required(specPath.Child("ippool"), "should be set for SR-IOV network of ips"))
+		}
+		seen := map[string]bool{}
+		for i, ip := range als.Spec.IPs {
+			if net.ParseIP(ip) == nil {
+				errs = append(errs, field.Invalid(ipsPath.Index(i), ip, "should be a valid IP"))
+			} else if seen[ip] {
+				errs = append(errs, field.Duplicate(ipsPath.Index(i), ip))
+			}
+			seen[ip] = true
+		}
+	}
+
+	errs = append(errs, validateIntOrPercent(als.Spec.MaxUnavailable, als.Spec.Replicas, specPath.Child("maxUnavailable"))...)
+	if budget := als.Spec.DisruptionBudget; budget != nil {
+		budgetPath := specPath.Child("disruptionBudget")
+		if (budget.MinAvailable == nil) == (budget.MaxUnavailable == nil) {
+			errs = append(errs, field.Invalid(budgetPath, "", "one and only one of minAvailable and maxUnavailable should be set"))
+		}
+		errs = append(errs, validateIntOrPercent(budget.MinAvailable, als.Spec.Replicas, budgetPath.Child("minAvailable"))...)
+		errs = append(errs, validateIntOrPercent(budget.MaxUnavailable, als.Spec.Replicas, budgetPath.Child("maxUnavailable"))...)
+	}
+
+	if policy := als.Spec.UnreachableNodePolicy; policy != nil && policy.TimeoutSeconds < 0 {
+		errs = append(errs, field.Invalid(specPath.Child("unreachableNodePolicy", "timeoutSeconds"), policy.TimeoutSeconds,
+			"should not be negative"))
+	}
+
+	claimNames := map[string]bool{}
+	for i, template := range als.Spec.VolumeClaimTemplates {
+		namePath := specPath.Child("volumeClaimTemplates").Index(i).Child("metadata", "name")
+		if template.Name == "" {
+			errs = append(errs, field.Required(namePath, ""))
+			continue
+		}
+		for _, msg := range validation.IsDNS1123Label(template.Name) {
+			errs = append(errs, field.Invalid(namePath, template.Name, msg))
+		}
+		if claimNames[template.Name] {
+			errs = append(errs, field.Duplicate(namePath, template.Name))
+		}
+		claimNames[template.Name] = true
+	}
+	return errs
+}
+
+func validateIntOrPercent(value *intstr.IntOrString, total int, fldPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	if value == nil {
+		return errs
+	}
+	if v, err := intstr.GetValueFromIntOrPercent(value, total, false); err != nil {
+		errs = append(errs, field.Invalid(fldPath, value.String(), err.Error()))
+	} else if v < 0 {
+		errs = append(errs, field.Invalid(fldPath, value.String(), "should not be negative"))
+	}
+	return errs
+}
+
+func validateAlcorSetUpdate(old, als *alcorv1alpha1.AlcorSet) field.ErrorList {
+	errs := field.ErrorList{}
+	specPath := field.NewPath("spec")
+	if old.Spec.OnVPC != als.Spec.OnVPC {
+		errs = append(errs, field.Forbidden(specPath.Child("onVpc"), "field is immutable"))
+	}
+	if old.Spec.NetworkBackend != als.Spec.NetworkBackend {
+		errs = append(errs, field.Forbidden(specPath.Child("networkBackend"), "field is immutable"))
+	}
+	if old.Spec.IPPool != als.Spec.IPPool {
+		errs = append(errs, field.Forbidden(specPath.Child("ippool"), "field is immutable"))
+	}
+	if !equality.Semantic.DeepEqual(old.Spec.VolumeClaimTemplates, als.Spec.VolumeClaimTemplates) {
+		errs = append(errs, field.Forbidden(specPath.Child("volumeClaimTemplates"), "field is immutable"))
+	}
+	// pods are not recreated for IP changes, so IPs of existing pods cannot be changed, including switching
+	// between ippool and fixed IPs. Pods beyond old ips are never created, their IPs can be added.
+	for i := 0; i < old.Spec.Replicas; i++ {
+		if i >= len(old.Spec.IPs) && len(old.Spec.IPs) > 0 {
+			break
+		}
+		if getIP(old, i) != getIP(als, i) {
+			errs = append(errs, field.Forbidden(specPath.Child("ips").Index(i),
+				fmt.Sprintf("IP of pod with index %d is immutable, scale down before changing it", i)))
+		}
+	}
+	return errs
+}
+
+// getIP returns IP in spec.ips for pod with given index, empty if not found
+func getIP(als *alcorv1alpha1.AlcorSet, podIdx int) string {
+	if podIdx < len(als.Spec.IPs) {
+		return als.Spec.IPs[podIdx]
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package alcorset
+
+import (
+	"strings"
+	"testing"
+
+	alcorv1alpha1 "github.com/onionpiece/alcorset/pkg/apis/alcor/v1alpha1"
+	controller "github.com/onionpiece/alcorset/pkg/controller/alcorset"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// newTestAlcorSet returns a valid AlcorSet claiming IPs from an ippool
+func newTestAlcorSet(replicas int) *alcorv1alpha1.AlcorSet {
+	return &alcorv1alpha1.AlcorSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "als", Namespace: "default"},
+		Spec: alcorv1alpha1.AlcorSetSpec{
+			Replicas:       replicas,
+			IPPool:         "pool",
+			HostnamePrefix: "als",
+			PodTemplateSpec: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app", Image: "app"}},
+				},
+			},
+		},
+	}
+}
+
+func TestValidateAlcorSet(t *testing.T) {
+	one := intstr.FromInt(1)
+	invalidPercent := intstr.FromString("abc")
+	tests := []struct {
+		name   string
+		mutate func(als *alcorv1alpha1.AlcorSet)
+		// fields expected to be rejected
+		invalid []string
+	}{
+		{name: "valid", mutate: func(als *alcorv1alpha1.AlcorSet) {}},
+		{name: "negative replicas", mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.Replicas = -1 },
+			invalid: []string{"spec.replicas"}},
+		{name: "negative mbps", mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.Mbps = -1 },
+			invalid: []string{"spec.mbps"}},
+		{name: "negative stageReplicas", mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.StageReplicas = -1 },
+			invalid: []string{"spec.stageReplicas"}},
+		{name: "Burst without burstSize", mutate: func(als *alcorv1alpha1.AlcorSet) {
+			als.Spec.PodManagementPolicy = alcorv1alpha1.BurstPodManagement
+		}, invalid: []string{"spec.burstSize"}},
+		{name: "negative burstSize", mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.BurstSize = -1 },
+			invalid: []string{"spec.burstSize"}},
+		{name: "hostnamePrefix not set", mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.HostnamePrefix = "" },
+			invalid: []string{"spec.hostnamePrefix"}},
+		{name: "hostnamePrefix invalid", mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.HostnamePrefix = "Als" },
+			invalid: []string{"spec.hostnamePrefix"}},
+		{name: "hostname of the biggest index too long", mutate: func(als *alcorv1alpha1.AlcorSet) {
+			als.Spec.HostnamePrefix = strings.Repeat("a", 62)
+			als.Spec.Replicas = 10
+		}, invalid: []string{"spec.hostnamePrefix"}},
+		{name: "serviceName invalid", mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.ServiceName = "1svc" },
+			invalid: []string{"spec.serviceName"}},
+		{name: "onVpc with another networkBackend", mutate: func(als *alcorv1alpha1.AlcorSet) {
+			als.Spec.IPPool = ""
+			als.Spec.OnVPC = true
+			als.Spec.NetworkBackend = controller.CalicoBackend
+		}, invalid: []string{"spec.networkBackend"}},
+		{name: "ippool on VPC", mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.NetworkBackend = controller.VPCBackend },
+			invalid: []string{"spec.ippool"}},
+		{name: "ips on VPC", mutate: func(als *alcorv1alpha1.AlcorSet) {
+			als.Spec.IPPool = ""
+			als.Spec.OnVPC = true
+			als.Spec.IPs = []string{"10.0.0.1", "10.0.0.2"}
+		}},
+		{name: "ips on SR-IOV without ippool", mutate: func(als *alcorv1alpha1.AlcorSet) {
+			als.Spec.IPPool = ""
+			als.Spec.IPs = []string{"10.0.0.1", "10.0.0.2"}
+		}, invalid: []string{"spec.ippool"}},
+		{name: "ips on calico without ippool", mutate: func(als *alcorv1alpha1.AlcorSet) {
+			als.Spec.IPPool = ""
+			als.Spec.NetworkBackend = controller.CalicoBackend
+			als.Spec.IPs = []string{"10.0.0.1", "10.0.0.2"}
+		}},
+		{name: "replicas more than ips", mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.IPs = []string{"10.0.0.1"} },
+			invalid: []string{"spec.replicas"}},
+		{name: "ip invalid", mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.IPs = []string{"10.0.0.256", "10.0.0.2"} },
+			invalid: []string{"spec.ips[0]"}},
+		{name: "ip duplicated", mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.IPs = []string{"10.0.0.1", "10.0.0.1"} },
+			invalid: []string{"spec.ips[1]"}},
+		{name: "maxUnavailable invalid", mutate: func(als *alcorv1alpha1.AlcorSet) { als.Spec.MaxUnavailable = &invalidPercent },
+			invalid: []string{"spec.maxUnavailable"}},
+		{name: "disruptionBudget with both minAvailable and maxUnavailable", mutate: func(als *alcorv1alpha1.AlcorSet) {
+			als.Spec.DisruptionBudget = &alcorv1alpha1.AlcorSetDisruptionBudget{MinAvailable: &one, MaxUnavailable: &one}
+		}, invalid: []string{"spec.disruptionBudget"}},
+		{name: "disruptionBudget with neither minAvailable nor maxUnavailable", mutate: func(als *alcorv1alpha1.AlcorSet) {
+			als.Spec.DisruptionBudget = &alcorv1alpha1.AlcorSetDisruptionBudget{}
+		}, invalid: []string{"spec.disruptionBudget"}},
+		{name: "negative timeoutSeconds of unreachableNodePolicy", mutate: func(als *alcorv1alpha1.AlcorSet) {
+			als.Spec.UnreachableNodePolicy = &alcorv1alpha1.AlcorSetUnreachableNodePolicy{TimeoutSeconds: -1}
+		}, invalid: []string{"spec.unreachableNodePolicy.timeoutSeconds"}},
+		{name: "volumeClaimTemplates without name or duplicated", mutate: func(als *alcorv1alpha1.AlcorSet) {
+			als.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{
+				{}, {ObjectMeta: metav1.ObjectMeta{Name: "data"}}, {ObjectMeta: metav1.ObjectMeta{Name: "data"}},
+			}
+		}, invalid: []string{"spec.volumeClaimTemplates[0].metadata.name", "spec.volumeClaimTemplates[2].metadata.name"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			als := newTestAlcorSet(2)
+			test.mutate(als)
+			assertInvalidFields(t, validateAlcorSet(als), test.invalid)
+		})
+	}
+}
+
+func TestValidateAlcorSetUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas int
+		oldIPs   []string
+		ips      []string
+		// fields expected to be rejected
+		invalid []string
+	}{
+		{name: "ips not changed", replicas: 2, oldIPs: []string{"10.0.0.1", "10.0.0.2"}, ips: []string{"10.0.0.1", "10.0.0.2"}},
+		{name: "ip of existing pod changed", replicas: 2, oldIPs: []string{"10.0.0.1", "10.0.0.2"},
+			ips: []string{"10.0.0.1", "10.0.0.3"}, invalid: []string{"spec.ips[1]"}},
+		{name: "ips of existing pods swapped", replicas: 2, oldIPs: []string{"10.0.0.1", "10.0.0.2"},
+			ips: []string{"10.0.0.2", "10.0.0.1"}, invalid: []string{"spec.ips[0]", "spec.ips[1]"}},
+		{name: "ip beyond replicas changed", replicas: 1, oldIPs: []string{"10.0.0.1", "10.0.0.2"}, ips: []string{"10.0.0.1", "10.0.0.3"}},
+		{name: "ip appended", replicas: 2, oldIPs: []string{"10.0.0.1", "10.0.0.2"}, ips: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{name: "ip added for pod never created", replicas: 2, oldIPs: []string{"10.0.0.1"}, ips: []string{"10.0.0.1", "10.0.0.2"}},
+		{name: "ip of existing pod removed", replicas: 2, oldIPs: []string{"10.0.0.1", "10.0.0.2"},
+			ips: []string{"10.0.0.1"}, invalid: []string{"spec.ips[1]"}},
+		{name: "switched from ippool to ips", replicas: 1, ips: []string{"10.0.0.1"}, invalid: []string{"spec.ips[0]"}},
+		{name: "switched from ippool to ips without pods", replicas: 0, ips: []string{"10.0.0.1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			old := newTestAlcorSet(test.replicas)
+			old.Spec.IPs = test.oldIPs
+			als := old.DeepCopy()
+			als.Spec.IPs = test.ips
+			assertInvalidFields(t, validateAlcorSetUpdate(old, als), test.invalid)
+		})
+	}
+}
+
+// assertInvalidFields fails if fields of errs are not the expected ones in order
+func assertInvalidFields(t *testing.T, errs field.ErrorList, expected []string) {
+	t.Helper()
+	fields := []string{}
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	if len(fields) != len(expected) {
+		t.Fatalf("expected invalid fields %v, got %v", expected, errs)
+	}
+	for i := range fields {
+		if fields[i] != expected[i] {
+			t.Errorf("expected invalid fields %v, got %v", expected, errs)
+			return
+		}
+	}
+}